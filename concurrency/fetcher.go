@@ -0,0 +1,275 @@
+package concurrency
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mmcdole/gofeed"
+	"github.com/mmcdole/gofeed/rss"
+)
+
+// defaults of the feed fetcher
+const (
+	// DefaultFetchInterval is the time between fetches of a feed that
+	// gives no hint
+	DefaultFetchInterval = 15 * time.Minute
+	// DefaultMinFetchInterval keeps feeds with short hints from being
+	// polled too often
+	DefaultMinFetchInterval = time.Minute
+	// maxFeedSize caps the feed read
+	maxFeedSize = 10 << 20
+)
+
+type FetcherOption func(*fetcher)
+
+// WithHTTPClient sets the client of the fetches, http.DefaultClient by
+// default, like the one of httpext.NewCustomClient(cfg).HTTPClient()
+func WithHTTPClient(c *http.Client) FetcherOption {
+	return func(f *fetcher) {
+		f.client = c
+	}
+}
+
+// WithFetchInterval sets the time between fetches when the feed and the
+// response give no hint
+func WithFetchInterval(d time.Duration) FetcherOption {
+	return func(f *fetcher) {
+		f.interval = d
+	}
+}
+
+// WithMinFetchInterval sets the shortest time between fetches, whatever
+// the hints say
+func WithMinFetchInterval(d time.Duration) FetcherOption {
+	return func(f *fetcher) {
+		f.minInterval = d
+	}
+}
+
+// fetcher fetches a feed over HTTP, it is not safe for concurrent use,
+// Subscribe runs one Fetch at a time
+type fetcher struct {
+	uri         string
+	client      *http.Client
+	interval    time.Duration
+	minInterval time.Duration
+
+	// validators of the last feed parsed, for conditional requests
+	etag         string
+	lastModified string
+	hints        feedHints
+}
+
+// NewFetcher returns a Fetcher for the RSS, Atom or JSON Feed at uri
+// a fetch is a conditional GET, a feed that did not change costs a 304
+// and yields no items, every fetch returns all the items of the feed,
+// Subscribe drops the ones it already sent
+// the next fetch is due after the longer of the response's Cache-Control
+// max-age and the RSS ttl, or after the fetch interval without either,
+// and never in one of the RSS skipHours
+func NewFetcher(uri string, opts ...FetcherOption) Fetcher {
+	f := &fetcher{
+		uri:         uri,
+		client:      http.DefaultClient,
+		interval:    DefaultFetchInterval,
+		minInterval: DefaultMinFetchInterval,
+	}
+
+	for _, opt := range opts {
+		opt(f)
+	}
+
+	return f
+}
+
+// feedHints are what a feed says about when to fetch it again
+type feedHints struct {
+	ttl       time.Duration
+	skipHours []int // in UTC
+}
+
+func (f *fetcher) Fetch() (items []Item, next time.Time, err error) {
+	return f.FetchContext(context.Background())
+}
+
+// FetchContext fetches the feed, ctx cancels the request
+func (f *fetcher) FetchContext(ctx context.Context) (items []Item, next time.Time, err error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.uri, nil)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/feed+json, application/xml;q=0.9, application/json;q=0.9, */*;q=0.8")
+
+	if f.etag != "" {
+		req.Header.Set("If-None-Match", f.etag)
+	}
+
+	if f.lastModified != "" {
+		req.Header.Set("If-Modified-Since", f.lastModified)
+	}
+
+	res, err := f.client.Do(req)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer res.Body.Close()
+
+	now := time.Now()
+
+	switch {
+	case res.StatusCode == http.StatusNotModified:
+		return nil, f.next(now, res.Header, f.hints), nil
+	case res.StatusCode == http.StatusGone:
+		// the publisher removed the feed for good
+		return nil, time.Time{}, fmt.Errorf("concurrency: fetch %s: %s: %w", f.uri, res.Status, ErrGone)
+	case res.StatusCode < 200 || res.StatusCode > 299:
+		return nil, time.Time{}, fmt.Errorf("concurrency: fetch %s: %s", f.uri, res.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(res.Body, maxFeedSize))
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	feed, hints, err := parseFeed(body)
+	if err != nil {
+		return nil, time.Time{}, fmt.Errorf("concurrency: parse %s: %w", f.uri, err)
+	}
+
+	// kept only once the feed is parsed, so a broken one is fetched whole
+	// next time
+	f.etag = res.Header.Get("ETag")
+	f.lastModified = res.Header.Get("Last-Modified")
+	f.hints = hints
+
+	for _, it := range feed.Items {
+		items = append(items, newItem(feed.Title, it))
+	}
+
+	return items, f.next(now, res.Header, hints), nil
+}
+
+// newItem converts a parsed item, the GUID is optional in RSS, the hash
+// of the link and title stands in for it then
+func newItem(channel string, it *gofeed.Item) Item {
+	item := Item{
+		GUID:       it.GUID,
+		Title:      it.Title,
+		Channel:    channel,
+		Link:       it.Link,
+		Categories: it.Categories,
+		Summary:    it.Description,
+		Content:    it.Content,
+	}
+
+	if item.GUID == "" {
+		item.GUID = item.ID()
+	}
+
+	if it.PublishedParsed != nil {
+		item.Published = *it.PublishedParsed
+	}
+
+	if it.UpdatedParsed != nil {
+		item.Updated = *it.UpdatedParsed
+	}
+
+	for _, p := range it.Authors {
+		if p != nil {
+			item.Authors = append(item.Authors, Author{Name: p.Name, Email: p.Email})
+		}
+	}
+
+	for _, e := range it.Enclosures {
+		if e == nil || e.URL == "" {
+			continue
+		}
+
+		// a missing or bad length is common, it is left unknown
+		length, _ := strconv.ParseInt(e.Length, 10, 64)
+		item.Enclosures = append(item.Enclosures, Enclosure{URL: e.URL, Type: e.Type, Length: max(length, 0)})
+	}
+
+	return item
+}
+
+// parseFeed parses an RSS, Atom or JSON feed, RSS is parsed on its own to
+// keep the ttl and skipHours the universal feed leaves out
+func parseFeed(body []byte) (*gofeed.Feed, feedHints, error) {
+	var hints feedHints
+
+	if gofeed.DetectFeedType(bytes.NewReader(body)) != gofeed.FeedTypeRSS {
+		feed, err := gofeed.NewParser().Parse(bytes.NewReader(body))
+		return feed, hints, err
+	}
+
+	rf, err := (&rss.Parser{}).Parse(bytes.NewReader(body))
+	if err != nil {
+		return nil, hints, err
+	}
+
+	if ttl, err := strconv.Atoi(strings.TrimSpace(rf.TTL)); err == nil && ttl > 0 {
+		hints.ttl = time.Duration(ttl) * time.Minute
+	}
+
+	for _, h := range rf.SkipHours {
+		if hour, err := strconv.Atoi(strings.TrimSpace(h)); err == nil && hour >= 0 && hour <= 23 {
+			hints.skipHours = append(hints.skipHours, hour)
+		}
+	}
+
+	feed, err := (&gofeed.DefaultRSSTranslator{}).Translate(rf)
+
+	return feed, hints, err
+}
+
+// next is when to fetch again, from the response's max-age, the feed's
+// ttl and skipHours
+func (f *fetcher) next(now time.Time, h http.Header, hints feedHints) time.Time {
+	d := max(maxAge(h), hints.ttl)
+	if d == 0 {
+		d = f.interval
+	}
+
+	next := now.Add(max(d, f.minInterval))
+
+	// skipHours are hours of the day in GMT the feed is not read
+	for range 24 {
+		if !slices.Contains(hints.skipHours, next.UTC().Hour()) {
+			break
+		}
+
+		next = next.UTC().Truncate(time.Hour).Add(time.Hour)
+	}
+
+	return next
+}
+
+// maxAge is the max-age of Cache-Control, 0 when absent or when the
+// response must not be reused
+func maxAge(h http.Header) time.Duration {
+	var age time.Duration
+
+	for _, directive := range strings.Split(h.Get("Cache-Control"), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+
+		switch strings.ToLower(name) {
+		case "no-cache", "no-store":
+			return 0
+		case "max-age":
+			if s, err := strconv.Atoi(strings.Trim(value, `"`)); err == nil && s > 0 {
+				age = time.Duration(s) * time.Second
+			}
+		}
+	}
+
+	return age
+}
@@ -0,0 +1,215 @@
+package concurrency
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+)
+
+const rssFeed = `<?xml version="1.0"?>
+<rss version="2.0"><channel>
+<title>Go Blog</title>
+<ttl>30</ttl>
+<item><title>Go 1.25</title><guid>tag:go.dev,1.25</guid>
+<link>https://go.dev/blog/go1.25</link>
+<pubDate>Tue, 12 Aug 2025 00:00:00 GMT</pubDate>
+<author>gopher@go.dev (Gopher)</author>
+<category>release</category>
+<description>Go 1.25 is released.</description>
+<enclosure url="https://go.dev/talk.mp3" type="audio/mpeg" length="1024"/>
+</item>
+<item><title>No guid</title><link>https://go.dev/blog/no-guid</link></item>
+</channel></rss>`
+
+var go125 = Item{
+	GUID:       "tag:go.dev,1.25",
+	Title:      "Go 1.25",
+	Channel:    "Go Blog",
+	Link:       "https://go.dev/blog/go1.25",
+	Published:  time.Date(2025, 8, 12, 0, 0, 0, 0, time.UTC),
+	Authors:    []Author{{Name: "Gopher", Email: "gopher@go.dev"}},
+	Categories: []string{"release"},
+	Summary:    "Go 1.25 is released.",
+	Enclosures: []Enclosure{{URL: "https://go.dev/talk.mp3", Type: "audio/mpeg", Length: 1024}},
+}
+
+var noGUID = Item{
+	GUID:    fallbackID("https://go.dev/blog/no-guid", "No guid"),
+	Title:   "No guid",
+	Channel: "Go Blog",
+	Link:    "https://go.dev/blog/no-guid",
+}
+
+const atomFeed = `<?xml version="1.0" encoding="utf-8"?>
+<feed xmlns="http://www.w3.org/2005/Atom">
+<title>Atom Blog</title>
+<entry><title>First</title><id>urn:uuid:1</id></entry>
+</feed>`
+
+const jsonFeed = `{"version": "https://jsonfeed.org/version/1.1", "title": "JSON Blog",
+"items": [{"id": "j1", "title": "Hello"}]}`
+
+func TestFetcher(t *testing.T) {
+	tests := []struct {
+		name         string
+		contentType  string
+		body         string
+		cacheControl string
+		want         []Item
+		interval     time.Duration
+	}{
+		{
+			name: "RSS", contentType: "application/rss+xml", body: rssFeed,
+			want:     []Item{go125, noGUID},
+			interval: 30 * time.Minute,
+		},
+		{
+			name: "RSS with a longer max-age", contentType: "application/rss+xml", body: rssFeed,
+			cacheControl: "public, max-age=7200",
+			want:         []Item{go125, noGUID},
+			interval:     2 * time.Hour,
+		},
+		{
+			name: "Atom", contentType: "application/atom+xml", body: atomFeed,
+			want:     []Item{{Title: "First", Channel: "Atom Blog", GUID: "urn:uuid:1"}},
+			interval: DefaultFetchInterval,
+		},
+		{
+			name: "JSON Feed", contentType: "application/feed+json", body: jsonFeed,
+			cacheControl: "max-age=5",
+			want:         []Item{{Title: "Hello", Channel: "JSON Blog", GUID: "j1"}},
+			// raised to the minimum
+			interval: DefaultMinFetchInterval,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			fetches, notModified := 0, 0
+
+			ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				fetches++
+
+				if tc.cacheControl != "" {
+					w.Header().Set("Cache-Control", tc.cacheControl)
+				}
+
+				if r.Header.Get("If-None-Match") == `"v1"` {
+					notModified++
+					w.WriteHeader(http.StatusNotModified)
+					return
+				}
+
+				w.Header().Set("ETag", `"v1"`)
+				w.Header().Set("Content-Type", tc.contentType)
+				w.Write([]byte(tc.body))
+			}))
+			defer ts.Close()
+
+			f := NewFetcher(ts.URL, WithHTTPClient(ts.Client()))
+
+			start := time.Now()
+
+			items, next, err := f.Fetch()
+			if err != nil {
+				t.Fatalf("Fetch: %v", err)
+			}
+
+			if len(items) != len(tc.want) {
+				t.Fatalf("items = %+v; want %+v", items, tc.want)
+			}
+
+			for i := range items {
+				if !reflect.DeepEqual(items[i], tc.want[i]) {
+					t.Errorf("item %d = %+v; want %+v", i, items[i], tc.want[i])
+				}
+			}
+
+			if d := next.Sub(start); d < tc.interval || d > tc.interval+time.Minute {
+				t.Errorf("next fetch in %v; want %v", d, tc.interval)
+			}
+
+			// unchanged, the server answers the conditional request with 304
+			items, _, err = f.Fetch()
+			if err != nil || len(items) != 0 {
+				t.Errorf("second Fetch = %v, %v; want no items", items, err)
+			}
+
+			if fetches != 2 || notModified != 1 {
+				t.Errorf("fetches = %d, not modified = %d; want 2 and 1", fetches, notModified)
+			}
+		})
+	}
+}
+
+func TestFetcherSkipHours(t *testing.T) {
+	f := &fetcher{interval: time.Hour}
+
+	now := time.Date(2025, 1, 1, 9, 30, 0, 0, time.UTC)
+	next := f.next(now, http.Header{}, feedHints{skipHours: []int{10, 11}})
+
+	if want := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC); !next.Equal(want) {
+		t.Errorf("next = %v; want %v", next, want)
+	}
+}
+
+func TestFetcherError(t *testing.T) {
+	status := http.StatusGone
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "error", status)
+	}))
+	defer ts.Close()
+
+	if _, _, err := NewFetcher(ts.URL).Fetch(); !errors.Is(err, ErrGone) {
+		t.Errorf("Fetch of a 410 = %v; want ErrGone", err)
+	}
+
+	status = http.StatusInternalServerError
+
+	if _, _, err := NewFetcher(ts.URL).Fetch(); err == nil || errors.Is(err, ErrGone) {
+		t.Errorf("Fetch of a 500 = %v; want an error other than ErrGone", err)
+	}
+}
+
+func TestItemID(t *testing.T) {
+	it := Item{Title: "No guid", Link: "https://go.dev/blog/no-guid"}
+
+	if id := it.ID(); id != noGUID.GUID {
+		t.Errorf("ID = %q; want the hash of the link and title, %q", id, noGUID.GUID)
+	}
+
+	if id := (Item{Title: "No guid", Link: "https://go.dev/blog/other"}).ID(); id == noGUID.GUID {
+		t.Error("items with different links have the same ID")
+	}
+
+	if id := go125.ID(); id != go125.GUID {
+		t.Errorf("ID = %q; want the GUID", id)
+	}
+}
+
+func TestItemJSON(t *testing.T) {
+	b, err := json.Marshal(go125)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Item
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(got, go125) {
+		t.Errorf("round trip = %+v; want %+v", got, go125)
+	}
+
+	// the zero fields are left out
+	b, _ = json.Marshal(Item{GUID: "1", Title: "t", Channel: "c"})
+	if want := `{"guid":"1","title":"t","channel":"c"}`; string(b) != want {
+		t.Errorf("Marshal = %s; want %s", b, want)
+	}
+}
@@ -7,6 +7,9 @@ import (
 	"time"
 )
 
+// Handler shows a handler that watches its request context for cancellation
+// the reusable version, with per route deadlines and a timeout response,
+// is the optionex.Timeout middleware
 func Handler(w http.ResponseWriter, r *http.Request) {
 	time.Sleep(10 * time.Second)
 	ctx := r.Context()
@@ -0,0 +1,37 @@
+package concurrency
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+// Author is the author of an Item
+type Author struct {
+	Name  string `json:"name,omitempty"`
+	Email string `json:"email,omitempty"`
+}
+
+// Enclosure is a media file attached to an Item, like a podcast episode
+type Enclosure struct {
+	URL    string `json:"url"`
+	Type   string `json:"type,omitempty"`
+	Length int64  `json:"length,omitempty"` // in bytes, 0 if unknown
+}
+
+// ID identifies the item, it is the GUID, or a hash of the link and title
+// when the feed leaves the GUID out, so the same item gets the same ID at
+// every fetch
+func (it Item) ID() string {
+	if it.GUID != "" {
+		return it.GUID
+	}
+
+	return fallbackID(it.Link, it.Title)
+}
+
+func fallbackID(link, title string) string {
+	// the separator keeps link "ab" title "c" apart from link "a" title "bc"
+	sum := sha256.Sum256([]byte(link + "\x00" + title))
+
+	return "sha256:" + hex.EncodeToString(sum[:16])
+}
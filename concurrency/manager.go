@@ -0,0 +1,208 @@
+package concurrency
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"sync"
+)
+
+// errors of the Manager
+var (
+	ErrManagerClosed = errors.New("concurrency: manager closed")
+	ErrFeedExists    = errors.New("concurrency: feed already added")
+	ErrFeedNotFound  = errors.New("concurrency: feed not found")
+)
+
+// FeedError is the error a named feed of a Manager closed with
+type FeedError struct {
+	Name string
+	Err  error
+}
+
+func (e *FeedError) Error() string {
+	return fmt.Sprintf("concurrency: feed %q: %v", e.Name, e.Err)
+}
+
+func (e *FeedError) Unwrap() error {
+	return e.Err
+}
+
+// managedFeed is a subscription of a Manager and the goroutine forwarding
+// its items
+type managedFeed struct {
+	sub  Subscription
+	quit chan struct{} // closed by Remove or Close
+	done chan struct{} // closed once sub is closed
+	err  error         // of sub.Close, set before done is closed
+}
+
+// Manager merges a set of named subscriptions that can change while it
+// runs, unlike Merge, it is a Subscription itself, closing it closes
+// every feed
+type Manager struct {
+	mu      sync.Mutex
+	feeds   map[string]*managedFeed
+	closed  bool
+	updates chan Item
+	wg      sync.WaitGroup // the forwarding goroutines
+
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewManager returns a Manager with no feeds
+func NewManager() *Manager {
+	return &Manager{
+		feeds:   make(map[string]*managedFeed),
+		updates: make(chan Item),
+	}
+}
+
+// Add starts merging the items of s under name, the Manager owns s from
+// then on, on an error s is left to the caller
+func (m *Manager) Add(name string, s Subscription) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return ErrManagerClosed
+	}
+
+	if _, ok := m.feeds[name]; ok {
+		return fmt.Errorf("%w: %q", ErrFeedExists, name)
+	}
+
+	f := &managedFeed{
+		sub:  s,
+		quit: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+	m.feeds[name] = f
+
+	m.wg.Add(1)
+	go m.forward(f)
+
+	return nil
+}
+
+// forward sends the items of f on m.updates until f is removed, a
+// subscription ending on its own stays until then, so its error is not
+// lost
+func (m *Manager) forward(f *managedFeed) {
+	defer m.wg.Done()
+	defer close(f.done)
+
+	in := f.sub.Updates()
+
+	for {
+		select {
+		case it, ok := <-in:
+			if !ok {
+				in = nil
+				break
+			}
+
+			select {
+			case m.updates <- it:
+			case <-f.quit:
+				f.err = f.sub.Close()
+				return
+			}
+		case <-f.quit:
+			f.err = f.sub.Close()
+			return
+		}
+	}
+}
+
+// Remove closes the feed name and returns the error it closed with, the
+// items it had not sent are dropped
+func (m *Manager) Remove(name string) error {
+	m.mu.Lock()
+	f, ok := m.feeds[name]
+	delete(m.feeds, name)
+	m.mu.Unlock()
+
+	if !ok {
+		return fmt.Errorf("%w: %q", ErrFeedNotFound, name)
+	}
+
+	close(f.quit)
+	<-f.done
+
+	return f.err
+}
+
+// List returns the names of the feeds, sorted
+func (m *Manager) List() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	names := make([]string, 0, len(m.feeds))
+	for name := range m.feeds {
+		names = append(names, name)
+	}
+
+	slices.Sort(names)
+
+	return names
+}
+
+// Stats returns the stats of the feeds by name, the feeds that are not a
+// StatsSubscription are left out
+func (m *Manager) Stats() map[string]SubscriptionStats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := make(map[string]SubscriptionStats, len(m.feeds))
+
+	for name, f := range m.feeds {
+		if s, ok := f.sub.(StatsSubscription); ok {
+			stats[name] = s.Stats()
+		}
+	}
+
+	return stats
+}
+
+// Updates merges the items of all the feeds, it is closed by Close
+func (m *Manager) Updates() <-chan Item {
+	return m.updates
+}
+
+// Close closes every feed and Updates, it returns the errors of the feeds
+// as FeedErrors joined with errors.Join, later calls return the same
+func (m *Manager) Close() error {
+	m.closeOnce.Do(func() {
+		m.mu.Lock()
+		m.closed = true
+		feeds := m.feeds
+		m.feeds = nil
+		m.mu.Unlock()
+
+		for _, f := range feeds {
+			close(f.quit)
+		}
+
+		var errs []error
+
+		for _, name := range slices.Sorted(maps.Keys(feeds)) {
+			f := feeds[name]
+			<-f.done
+
+			if f.err != nil {
+				errs = append(errs, &FeedError{Name: name, Err: f.err})
+			}
+		}
+
+		// feeds being removed are done too
+		m.wg.Wait()
+		close(m.updates)
+
+		m.closeErr = errors.Join(errs...)
+	})
+
+	return m.closeErr
+}
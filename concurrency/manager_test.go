@@ -0,0 +1,156 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// errSub is a chanSub that closes with err
+type errSub struct {
+	*chanSub
+	err error
+}
+
+func (s errSub) Close() error {
+	s.chanSub.Close()
+	return s.err
+}
+
+func TestManager(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		m := NewManager()
+
+		a, b := newChanSub(), newChanSub()
+		if err := m.Add("a", a); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.Add("b", b); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := m.Add("a", newChanSub()); !errors.Is(err, ErrFeedExists) {
+			t.Errorf("Add of a name in use = %v; want ErrFeedExists", err)
+		}
+
+		if got := m.List(); !slices.Equal(got, []string{"a", "b"}) {
+			t.Errorf("List = %v; want [a b]", got)
+		}
+
+		go func() { a.updates <- Item{GUID: "a1"} }()
+		if it := <-m.Updates(); it.GUID != "a1" {
+			t.Errorf("got %+v; want a1", it)
+		}
+
+		go func() { b.updates <- Item{GUID: "b1"} }()
+		if it := <-m.Updates(); it.GUID != "b1" {
+			t.Errorf("got %+v; want b1", it)
+		}
+
+		// a is closed on removal, b keeps going
+		if err := m.Remove("a"); err != nil {
+			t.Errorf("Remove = %v", err)
+		}
+
+		select {
+		case <-a.closed:
+		default:
+			t.Error("a not closed by Remove")
+		}
+
+		if err := m.Remove("a"); !errors.Is(err, ErrFeedNotFound) {
+			t.Errorf("second Remove = %v; want ErrFeedNotFound", err)
+		}
+
+		// a feed can be added again under a removed name
+		c := newChanSub()
+		if err := m.Add("a", c); err != nil {
+			t.Fatal(err)
+		}
+
+		go func() { c.updates <- Item{GUID: "c1"} }()
+		if it := <-m.Updates(); it.GUID != "c1" {
+			t.Errorf("got %+v; want c1", it)
+		}
+
+		if err := m.Close(); err != nil {
+			t.Errorf("Close = %v", err)
+		}
+
+		if _, ok := <-m.Updates(); ok {
+			t.Error("Updates not closed")
+		}
+
+		if err := m.Add("d", newChanSub()); !errors.Is(err, ErrManagerClosed) {
+			t.Errorf("Add after Close = %v; want ErrManagerClosed", err)
+		}
+	})
+}
+
+func TestManagerCloseErrors(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		m := NewManager()
+
+		downErr, goneErr := errors.New("feed down"), errors.New("feed gone")
+
+		m.Add("ok", newChanSub())
+		m.Add("down", errSub{newChanSub(), downErr})
+		m.Add("gone", errSub{newChanSub(), goneErr})
+
+		// an item is left pending, Close does not wait for a receiver
+		pending := newChanSub()
+		m.Add("pending", pending)
+		go func() { pending.updates <- Item{GUID: "never received"} }()
+		synctest.Wait()
+
+		err := m.Close()
+		if !errors.Is(err, downErr) || !errors.Is(err, goneErr) {
+			t.Fatalf("Close = %v; want both feed errors", err)
+		}
+
+		var fe *FeedError
+		if !errors.As(err, &fe) || fe.Name != "down" {
+			t.Errorf("first FeedError = %v; want the one of down", fe)
+		}
+
+		if again := m.Close(); again != err {
+			t.Errorf("second Close = %v; want %v", again, err)
+		}
+	})
+}
+
+func TestManagerStats(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		m := NewManager()
+		defer m.Close()
+
+		m.Add("plain", newChanSub())
+		m.Add("fetched", SubscribeContext(context.Background(), &scriptedFetcher{errs: []error{errors.New("feed down")}}))
+
+		synctest.Wait()
+
+		stats := m.Stats()
+		if _, ok := stats["plain"]; ok || len(stats) != 1 {
+			t.Fatalf("Stats = %v; want only the feed with stats", stats)
+		}
+
+		if st := stats["fetched"]; st.Health != Degraded || st.Failures != 1 {
+			t.Errorf("stats of fetched = %+v; want degraded after a failure", st)
+		}
+
+		// the retry succeeds
+		time.Sleep(DefaultBackoff.Max)
+
+		if it := <-m.Updates(); it.GUID != "ok" {
+			t.Errorf("got %+v; want the fetched item", it)
+		}
+
+		if st := m.Stats()["fetched"]; st.Health != Healthy {
+			t.Errorf("stats of fetched = %+v; want healthy", st)
+		}
+	})
+}
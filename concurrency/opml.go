@@ -0,0 +1,246 @@
+package concurrency
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// FeedSpec is a feed of an OPML subscription list
+type FeedSpec struct {
+	Title   string
+	URL     string // of the feed, the xmlUrl of the outline
+	HTMLURL string // of the site
+	// Categories are the outlines holding the feed, outermost first
+	Categories []string
+	// Interval sets WithFetchInterval, 0 leaves the default, it is the
+	// refreshInterval attribute, in minutes, or a Go duration like "90s"
+	Interval time.Duration
+}
+
+// opml is an OPML 2.0 document, only the parts describing subscriptions
+type opml struct {
+	XMLName xml.Name  `xml:"opml"`
+	Version string    `xml:"version,attr"`
+	Title   string    `xml:"head>title,omitempty"`
+	Body    []outline `xml:"body>outline"`
+}
+
+type outline struct {
+	Text            string    `xml:"text,attr"`
+	Title           string    `xml:"title,attr,omitempty"`
+	Type            string    `xml:"type,attr,omitempty"`
+	XMLURL          string    `xml:"xmlUrl,attr,omitempty"`
+	HTMLURL         string    `xml:"htmlUrl,attr,omitempty"`
+	RefreshInterval string    `xml:"refreshInterval,attr,omitempty"`
+	Outlines        []outline `xml:"outline"`
+}
+
+// ParseOPML reads the feeds of an OPML document, in document order, the
+// outlines without an xmlUrl are categories, nested to any depth
+func ParseOPML(r io.Reader) ([]FeedSpec, error) {
+	var doc opml
+	if err := xml.NewDecoder(r).Decode(&doc); err != nil {
+		return nil, fmt.Errorf("concurrency: parse OPML: %w", err)
+	}
+
+	var feeds []FeedSpec
+
+	var walk func(outlines []outline, categories []string)
+	walk = func(outlines []outline, categories []string) {
+		for _, o := range outlines {
+			title := o.Title
+			if title == "" {
+				title = o.Text
+			}
+
+			if o.XMLURL == "" {
+				walk(o.Outlines, append(slices.Clip(categories), title))
+				continue
+			}
+
+			feed := FeedSpec{
+				Title:      title,
+				URL:        o.XMLURL,
+				HTMLURL:    o.HTMLURL,
+				Categories: slices.Clone(categories),
+			}
+
+			// a bad value leaves the default, it is no reason to
+			// drop the feed, or the document
+			feed.Interval = parseRefreshInterval(o.RefreshInterval)
+
+			feeds = append(feeds, feed)
+		}
+	}
+
+	walk(doc.Body, nil)
+
+	return feeds, nil
+}
+
+// parseRefreshInterval parses a number of minutes, or a Go duration, it
+// returns 0 for anything else
+func parseRefreshInterval(v string) time.Duration {
+	if n, err := strconv.Atoi(v); err == nil {
+		return time.Duration(max(n, 0)) * time.Minute
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		return 0
+	}
+
+	return max(d, 0)
+}
+
+// formatRefreshInterval writes whole minutes as a number, as the other
+// readers do, and anything else as a Go duration
+func formatRefreshInterval(d time.Duration) string {
+	if d%time.Minute == 0 {
+		return strconv.FormatInt(int64(d/time.Minute), 10)
+	}
+
+	return d.String()
+}
+
+// WriteOPML writes feeds as an OPML 2.0 document titled title, the feeds
+// sharing categories are nested in the same outlines, so a document read
+// by ParseOPML is written back the same
+func WriteOPML(w io.Writer, title string, feeds []FeedSpec) error {
+	doc := opml{Version: "2.0", Title: title}
+
+	for _, f := range feeds {
+		// the outline of each category, created on its first feed
+		outlines := &doc.Body
+		for _, c := range f.Categories {
+			i := slices.IndexFunc(*outlines, func(o outline) bool {
+				return o.XMLURL == "" && o.Text == c
+			})
+			if i < 0 {
+				*outlines = append(*outlines, outline{Text: c})
+				i = len(*outlines) - 1
+			}
+
+			outlines = &(*outlines)[i].Outlines
+		}
+
+		o := outline{
+			Text:    f.Title,
+			Title:   f.Title,
+			Type:    "rss",
+			XMLURL:  f.URL,
+			HTMLURL: f.HTMLURL,
+		}
+		if o.Text == "" {
+			o.Text = f.URL
+		}
+
+		if f.Interval > 0 {
+			o.RefreshInterval = formatRefreshInterval(f.Interval)
+		}
+
+		*outlines = append(*outlines, o)
+	}
+
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(w, "\n")
+
+	return err
+}
+
+type OPMLOption func(*opmlConfig)
+
+type opmlConfig struct {
+	fetcherOpts   []FetcherOption
+	subscribeOpts []SubscribeOption
+}
+
+// WithOPMLFetcherOptions sets the options of the fetcher of every feed,
+// the refreshInterval of a feed overrides WithFetchInterval
+func WithOPMLFetcherOptions(opts ...FetcherOption) OPMLOption {
+	return func(c *opmlConfig) {
+		c.fetcherOpts = append(c.fetcherOpts, opts...)
+	}
+}
+
+// WithOPMLSubscribeOptions sets the options of the subscription of every
+// feed, a SeenStore given with WithSeenStore is shared by all of them
+func WithOPMLSubscribeOptions(opts ...SubscribeOption) OPMLOption {
+	return func(c *opmlConfig) {
+		c.subscribeOpts = append(c.subscribeOpts, opts...)
+	}
+}
+
+// SubscribeFeeds subscribes to each feed with NewFetcher and Subscribe,
+// and merges them with Merge, a feed listed twice is subscribed once
+// a feed that ends, like one answering 410 Gone, leaves the others going,
+// its error is returned by Close, with no feeds the subscription is
+// closed already
+func SubscribeFeeds(feeds []FeedSpec, opts ...OPMLOption) Subscription {
+	var c opmlConfig
+	for _, opt := range opts {
+		opt(&c)
+	}
+
+	var subs []Subscription
+
+	seen := make(map[string]bool)
+
+	for _, f := range feeds {
+		if seen[f.URL] {
+			continue
+		}
+		seen[f.URL] = true
+
+		fetcherOpts := slices.Clip(c.fetcherOpts)
+		if f.Interval > 0 {
+			fetcherOpts = append(fetcherOpts, WithFetchInterval(f.Interval))
+		}
+
+		subs = append(subs, Subscribe(NewFetcher(f.URL, fetcherOpts...), c.subscribeOpts...))
+	}
+
+	if len(subs) == 0 {
+		return newClosedSub()
+	}
+
+	return Merge(subs...)
+}
+
+// closedSub is a Subscription that has ended, with no items
+type closedSub struct{ updates chan Item }
+
+func newClosedSub() closedSub {
+	s := closedSub{updates: make(chan Item)}
+	close(s.updates)
+	return s
+}
+
+func (s closedSub) Updates() <-chan Item { return s.updates }
+
+func (s closedSub) Close() error { return nil }
+
+// SubscribeOPML subscribes to the feeds of an OPML document, see
+// ParseOPML and SubscribeFeeds
+func SubscribeOPML(r io.Reader, opts ...OPMLOption) (Subscription, error) {
+	feeds, err := ParseOPML(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return SubscribeFeeds(feeds, opts...), nil
+}
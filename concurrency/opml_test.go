@@ -0,0 +1,179 @@
+package concurrency
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const feedsOPML = `<?xml version="1.0" encoding="UTF-8"?>
+<opml version="2.0">
+<head><title>My feeds</title></head>
+<body>
+  <outline text="Go">
+    <outline type="rss" text="Go Blog" xmlUrl="https://go.dev/blog/feed.atom" htmlUrl="https://go.dev/blog" refreshInterval="1h"/>
+    <outline text="Community">
+      <outline type="rss" text="Gopher" title="Gopher Weekly" xmlUrl="https://example.com/gopher.xml"/>
+    </outline>
+  </outline>
+  <outline type="rss" text="News" xmlUrl="https://example.com/news.xml"/>
+</body>
+</opml>`
+
+var opmlFeeds = []FeedSpec{
+	{Title: "Go Blog", URL: "https://go.dev/blog/feed.atom", HTMLURL: "https://go.dev/blog", Categories: []string{"Go"}, Interval: time.Hour},
+	{Title: "Gopher Weekly", URL: "https://example.com/gopher.xml", Categories: []string{"Go", "Community"}},
+	{Title: "News", URL: "https://example.com/news.xml"},
+}
+
+func TestParseOPML(t *testing.T) {
+	feeds, err := ParseOPML(strings.NewReader(feedsOPML))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(feeds, opmlFeeds) {
+		t.Errorf("feeds = %+v; want %+v", feeds, opmlFeeds)
+	}
+
+	// minutes as other readers write them, a bad value is left out
+	doc := `<opml version="2.0"><body>
+<outline xmlUrl="https://example.com/a.xml" refreshInterval="15"/>
+<outline xmlUrl="https://example.com/b.xml" refreshInterval="soon"/>
+</body></opml>`
+
+	feeds, err = ParseOPML(strings.NewReader(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(feeds) != 2 || feeds[0].Interval != 15*time.Minute || feeds[1].Interval != 0 {
+		t.Errorf("feeds = %+v; want 15m and the default", feeds)
+	}
+}
+
+func TestWriteOPML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteOPML(&buf, "My feeds", opmlFeeds); err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(buf.String(), `refreshInterval="60"`) {
+		t.Errorf("the interval is missing from\n%s", buf.String())
+	}
+
+	feeds, err := ParseOPML(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(feeds, opmlFeeds) {
+		t.Errorf("round trip = %+v; want %+v", feeds, opmlFeeds)
+	}
+}
+
+func TestSubscribeOPML(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/rss+xml")
+		fmt.Fprintf(w, `<rss version="2.0"><channel><title>%s</title>
+<item><title>Hello</title><guid>%[1]s/1</guid></item>
+</channel></rss>`, strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	defer ts.Close()
+
+	doc := fmt.Sprintf(`<opml version="2.0"><body>
+<outline text="a" xmlUrl="%[1]s/a"/>
+<outline text="tech"><outline text="b" xmlUrl="%[1]s/b"/></outline>
+<outline text="a again" xmlUrl="%[1]s/a"/>
+</body></opml>`, ts.URL)
+
+	s, err := SubscribeOPML(strings.NewReader(doc), WithOPMLFetcherOptions(WithHTTPClient(ts.Client())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := make(map[string]bool)
+	timeout := time.After(5 * time.Second)
+
+	for len(got) < 2 {
+		select {
+		case it := <-s.Updates():
+			got[it.GUID] = true
+		case <-timeout:
+			t.Fatalf("got %v; want the items of a and b", got)
+		}
+	}
+
+	if !got["a/1"] || !got["b/1"] {
+		t.Errorf("got %v; want a/1 and b/1", got)
+	}
+
+	// a is subscribed once, its item does not come again
+	select {
+	case it := <-s.Updates():
+		t.Errorf("got %+v again", it)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := s.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+}
+
+func TestSubscribeFeedsGone(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/gone" {
+			http.Error(w, "gone", http.StatusGone)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/rss+xml")
+		w.Write([]byte(`<rss version="2.0"><channel><title>live</title>
+<item><title>Hello</title><guid>live/1</guid></item>
+</channel></rss>`))
+	}))
+	defer ts.Close()
+
+	s := SubscribeFeeds([]FeedSpec{{URL: ts.URL + "/gone"}, {URL: ts.URL + "/live"}},
+		WithOPMLFetcherOptions(WithHTTPClient(ts.Client())))
+
+	// the gone feed sends nothing, the live one goes on
+	timeout := time.After(5 * time.Second)
+
+	select {
+	case it := <-s.Updates():
+		if it.GUID != "live/1" {
+			t.Fatalf("got %+v; want the live item", it)
+		}
+	case <-timeout:
+		t.Fatal("no item from the live feed")
+	}
+
+	select {
+	case it := <-s.Updates():
+		t.Errorf("got %+v; want nothing more", it)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	if err := s.Close(); !errors.Is(err, ErrGone) {
+		t.Errorf("Close = %v; want ErrGone", err)
+	}
+}
+
+func TestSubscribeFeedsEmpty(t *testing.T) {
+	s := SubscribeFeeds(nil)
+
+	if _, ok := <-s.Updates(); ok {
+		t.Error("Updates of no feeds not closed")
+	}
+
+	if err := s.Close(); err != nil {
+		t.Errorf("Close = %v", err)
+	}
+}
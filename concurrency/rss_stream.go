@@ -2,16 +2,31 @@ package concurrency
 
 // this is a exact copy from google code example
 import (
+	"context"
+	"errors"
 	"fmt"
 	"math/rand"
+	"sync"
 	"time"
-
-	rss "github.com/mmcdole/gofeed"
 )
 
 // STARTITEM OMIT
-// An Item is a stripped-down RSS item.
-type Item struct{ Title, Channel, GUID string }
+// An Item is an RSS, Atom or JSON Feed item.  Only Title, Channel and
+// GUID are always set; ID stands in for a missing GUID.  Items marshal to
+// JSON for downstream sinks.
+type Item struct {
+	GUID       string      `json:"guid"`
+	Title      string      `json:"title"`
+	Channel    string      `json:"channel"`
+	Link       string      `json:"link,omitempty"`
+	Published  time.Time   `json:"published,omitzero"`
+	Updated    time.Time   `json:"updated,omitzero"`
+	Authors    []Author    `json:"authors,omitempty"`
+	Categories []string    `json:"categories,omitempty"`
+	Summary    string      `json:"summary,omitempty"`
+	Content    string      `json:"content,omitempty"`
+	Enclosures []Enclosure `json:"enclosures,omitempty"`
+}
 
 // STOPITEM OMIT
 
@@ -24,6 +39,43 @@ type Fetcher interface {
 
 // STOPFETCHER OMIT
 
+// A ContextFetcher is a Fetcher whose fetch is canceled with ctx, on
+// cancellation FetchContext returns ctx's error.
+type ContextFetcher interface {
+	FetchContext(ctx context.Context) (items []Item, next time.Time, err error)
+}
+
+// WithContext adapts f to a ContextFetcher.  A fetch of a plain Fetcher
+// can not be stopped, FetchContext returns when ctx is done and leaves
+// the fetch to finish on its own.
+func WithContext(f Fetcher) ContextFetcher {
+	if cf, ok := f.(ContextFetcher); ok {
+		return cf
+	}
+	return fetcherAdapter{f}
+}
+
+type fetcherAdapter struct{ f Fetcher }
+
+func (a fetcherAdapter) FetchContext(ctx context.Context) ([]Item, time.Time, error) {
+	type result struct {
+		items []Item
+		next  time.Time
+		err   error
+	}
+	done := make(chan result, 1) // the fetch never blocks on it
+	go func() {
+		items, next, err := a.f.Fetch()
+		done <- result{items, next, err}
+	}()
+	select {
+	case r := <-done:
+		return r.items, r.next, r.err
+	case <-ctx.Done():
+		return nil, time.Time{}, ctx.Err()
+	}
+}
+
 // STARTSUBSCRIPTION OMIT
 // A Subscription delivers Items over a channel.  Close cancels the
 // subscription, closes the Updates channel, and returns the last fetch error,
@@ -37,23 +89,59 @@ type Subscription interface {
 
 // STARTSUBSCRIBE OMIT
 // Subscribe returns a new Subscription that uses fetcher to fetch Items.
-func Subscribe(fetcher Fetcher) Subscription {
+func Subscribe(fetcher Fetcher, opts ...SubscribeOption) Subscription {
+	return SubscribeContext(context.Background(), WithContext(fetcher), opts...)
+}
+
+// STOPSUBSCRIBE OMIT
+
+// SubscribeContext returns a new Subscription that uses fetcher to fetch
+// Items.  The subscription ends when ctx is done, as if Close was called.
+// Ending it cancels the fetch in flight and waits for it to return.
+// A failing fetch is retried after a growing backoff, a fetch failing
+// with ErrGone stops the subscription once the items pending are sent,
+// Close then returns that error.  The returned Subscription is a
+// StatsSubscription.
+func SubscribeContext(ctx context.Context, fetcher ContextFetcher, opts ...SubscribeOption) Subscription {
+	ctx, cancel := context.WithCancel(ctx)
 	s := &sub{
-		fetcher: fetcher,
-		updates: make(chan Item),       // for Updates
-		closing: make(chan chan error), // for Close
+		fetcher:      fetcher,
+		ctx:          ctx,
+		cancel:       cancel,
+		updates:      make(chan Item),       // for Updates
+		closing:      make(chan chan error), // for Close
+		done:         make(chan struct{}),
+		backoff:      DefaultBackoff,
+		errorHistory: DefaultErrorHistory,
+		failingAfter: DefaultFailingAfter,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.seen == nil {
+		s.seen = NewMemorySeenStore(DefaultSeenCapacity, 0)
 	}
 	go s.loop()
 	return s
 }
 
-// STOPSUBSCRIBE OMIT
-
 // sub implements the Subscription interface.
 type sub struct {
-	fetcher Fetcher         // fetches items
-	updates chan Item       // sends items to the user
-	closing chan chan error // for Close
+	fetcher ContextFetcher     // fetches items
+	ctx     context.Context    // canceled when the subscription ends
+	cancel  context.CancelFunc // cancels ctx
+	updates chan Item          // sends items to the user
+	closing chan chan error    // for Close
+	done    chan struct{}      // closed when loop returns
+	err     error              // last fetch error, set before done is closed
+
+	backoff      Backoff
+	errorHistory int
+	failingAfter int
+	seen         SeenStore // GUIDs already sent
+
+	statsMu sync.Mutex
+	stats   SubscriptionStats
 }
 
 // STARTUPDATES OMIT
@@ -68,8 +156,13 @@ func (s *sub) Updates() <-chan Item {
 func (s *sub) Close() error {
 	// STOPCLOSESIG OMIT
 	errc := make(chan error)
-	s.closing <- errc // HLchan
-	return <-errc     // HLchan
+	select {
+	case s.closing <- errc: // HLchan
+		return <-errc // HLchan
+	case <-s.done:
+		// ended with its context
+		return s.err
+	}
 }
 
 // STOPCLOSE OMIT
@@ -107,7 +200,7 @@ func (s *sub) loopFetchOnly() {
 		select {
 		case <-startFetch:
 			var fetched []Item
-			fetched, next, err = s.fetcher.Fetch()
+			fetched, next, err = s.fetcher.FetchContext(s.ctx)
 			if err != nil {
 				next = time.Now().Add(10 * time.Second)
 				break
@@ -171,7 +264,7 @@ func (s *sub) mergedLoop() {
 			// STARTFETCHCASE OMIT
 		case <-startFetch: // HLcases
 			var fetched []Item
-			fetched, next, err = s.fetcher.Fetch() // HLfetch
+			fetched, next, err = s.fetcher.FetchContext(s.ctx) // HLfetch
 			if err != nil {
 				next = time.Now().Add(10 * time.Second)
 				break
@@ -219,7 +312,7 @@ func (s *sub) dedupeLoop() {
 		// STARTDEDUPE OMIT
 		case <-startFetch:
 			var fetched []Item
-			fetched, next, err = s.fetcher.Fetch() // HLfetch
+			fetched, next, err = s.fetcher.FetchContext(s.ctx) // HLfetch
 			if err != nil {
 				next = time.Now().Add(10 * time.Second)
 				break
@@ -237,31 +330,38 @@ func (s *sub) dedupeLoop() {
 	}
 }
 
+// fetchResult is the outcome of a Fetch run by loop.
+type fetchResult struct {
+	fetched []Item
+	next    time.Time
+	err     error
+}
+
 // loop periodically fetches Items, sends them on s.updates, and exits
 // when Close is called.  It extends dedupeLoop with logic to run
 // Fetch asynchronously.
 func (s *sub) loop() {
 	const maxPending = 10
-	type fetchResult struct {
-		fetched []Item
-		next    time.Time
-		err     error
-	}
 	// STARTFETCHDONE OMIT
 	var fetchDone chan fetchResult // if non-nil, Fetch is running // HL
 	// STOPFETCHDONE OMIT
 	var pending []Item
+	var queued = make(map[string]bool) // IDs in pending
 	var next time.Time
 	var err error
-	var seen = make(map[string]bool)
+	var terminal error // set when the feed is gone
 	for {
+		if terminal != nil && len(pending) == 0 {
+			s.stop(nil, terminal)
+			return
+		}
 		var fetchDelay time.Duration
 		if now := time.Now(); next.After(now) {
 			fetchDelay = next.Sub(now)
 		}
 		// STARTFETCHIF OMIT
 		var startFetch <-chan time.Time
-		if fetchDone == nil && len(pending) < maxPending { // HLfetch
+		if fetchDone == nil && len(pending) < maxPending && terminal == nil { // HLfetch
 			startFetch = time.After(fetchDelay) // enable fetch case
 		}
 		// STOPFETCHIF OMIT
@@ -276,35 +376,62 @@ func (s *sub) loop() {
 		case <-startFetch: // HLfetch
 			fetchDone = make(chan fetchResult, 1) // HLfetch
 			go func() {
-				fetched, next, err := s.fetcher.Fetch()
+				fetched, next, err := s.fetcher.FetchContext(s.ctx)
 				fetchDone <- fetchResult{fetched, next, err}
 			}()
 		case result := <-fetchDone: // HLfetch
 			fetchDone = nil // HLfetch
 			// Use result.fetched, result.next, result.err
 			// STOPFETCHASYNC OMIT
+			if s.ctx.Err() != nil {
+				break // canceled, the subscription is ending
+			}
 			fetched := result.fetched
 			next, err = result.next, result.err
 			if err != nil {
-				next = time.Now().Add(10 * time.Second)
+				if errors.Is(err, ErrGone) {
+					terminal = err
+				}
+				next = s.recordFailure(err, terminal != nil)
 				break
 			}
+			s.recordSuccess(next)
 			for _, item := range fetched {
-				if id := item.GUID; !seen[id] { // HLdupe
+				// recorded as seen once sent, an item pending at
+				// Close is not lost to a FileSeenStore
+				id := item.ID()
+				if !queued[id] && !s.seen.Seen(id) { // HLdupe
+					queued[id] = true
 					pending = append(pending, item)
-					seen[id] = true // HLdupe
 				}
 			}
 		case errc := <-s.closing:
+			s.stop(fetchDone, err)
 			errc <- err
-			close(s.updates)
+			return
+		case <-s.ctx.Done():
+			s.stop(fetchDone, err)
 			return
 		case updates <- first:
+			s.seen.Add(first.ID())
+			delete(queued, first.ID())
 			pending = pending[1:]
 		}
 	}
 }
 
+// stop ends loop: it cancels the fetch in flight, if any, waits for it,
+// and closes s.updates.  err is what Close returns from now on.
+func (s *sub) stop(fetchDone <-chan fetchResult, err error) {
+	s.cancel()
+	if fetchDone != nil {
+		<-fetchDone // the canceled fetch's error is not reported
+	}
+	close(s.updates)
+	s.err = err
+	close(s.done)
+}
+
 // naiveMerge is a version of Merge that doesn't quite work right.  In
 // particular, the goroutines it starts may block forever on m.updates
 // if the receiver stops receiving.
@@ -371,10 +498,18 @@ func Merge(subs ...Subscription) Subscription {
 	// STARTMERGE OMIT
 	for _, sub := range subs {
 		go func(s Subscription) {
+			in := s.Updates()
 			for {
 				var it Item
+				var ok bool
 				select {
-				case it = <-s.Updates():
+				case it, ok = <-in:
+					if !ok {
+						// s ended on its own, wait for Close to
+						// collect its error
+						in = nil
+						continue
+					}
 				case <-m.quit: // HL
 					m.errs <- s.Close() // HL
 					return              // HL
@@ -432,18 +567,35 @@ func NaiveDedupe(in <-chan Item) <-chan Item {
 
 type deduper struct {
 	s       Subscription
+	seen    SeenStore // GUIDs already sent
 	updates chan Item
 	closing chan chan error
 }
 
+type DedupeOption func(*deduper)
+
+// WithDedupeStore sets the store of the GUIDs seen, a FileSeenStore keeps
+// them across restarts.  The caller closes it after the Subscription.
+func WithDedupeStore(store SeenStore) DedupeOption {
+	return func(d *deduper) {
+		d.seen = store
+	}
+}
+
 // Dedupe converts a Subscription that may send duplicate Items into
 // one that doesn't.
-func Dedupe(s Subscription) Subscription {
+func Dedupe(s Subscription, opts ...DedupeOption) Subscription {
 	d := &deduper{
 		s:       s,
 		updates: make(chan Item),
 		closing: make(chan chan error),
 	}
+	for _, opt := range opts {
+		opt(d)
+	}
+	if d.seen == nil {
+		d.seen = NewMemorySeenStore(DefaultSeenCapacity, 0)
+	}
 	go d.loop()
 	return d
 }
@@ -452,17 +604,20 @@ func (d *deduper) loop() {
 	in := d.s.Updates() // enable receive
 	var pending Item
 	var out chan Item // disable send
-	seen := make(map[string]bool)
 	for {
 		select {
-		case it := <-in:
-			if !seen[it.GUID] {
+		case it, ok := <-in:
+			if !ok {
+				in = nil // the subscription ended, wait for Close
+				break
+			}
+			if !d.seen.Seen(it.ID()) {
 				pending = it
 				in = nil        // disable receive
 				out = d.updates // enable send
-				seen[it.GUID] = true
 			}
 		case out <- pending:
+			d.seen.Add(pending.ID())
 			in = d.s.Updates() // enable receive
 			out = nil          // disable send
 		case errc := <-d.closing:
@@ -530,42 +685,6 @@ func realFetch(domain string) Fetcher {
 	return NewFetcher(fmt.Sprintf("http://%s/feeds/posts/default?alt=rss", domain))
 }
 
-type fetcher struct {
-	uri   string
-	feed  *rss.Feed
-	items []Item
-}
-
-// NewFetcher returns a Fetcher for uri.
-func NewFetcher(uri string) Fetcher {
-	f := &fetcher{
-		uri: uri,
-	}
-	newChans := func(feed *rss.Feed, chans []*rss.Channel) {}
-	newItems := func(feed *rss.Feed, ch *rss.Channel, items []*rss.Item) {
-		for _, it := range items {
-			f.items = append(f.items, Item{
-				Channel: ch.Title,
-				GUID:    it.Guid,
-				Title:   it.Title,
-			})
-		}
-	}
-	f.feed = rss.NewParser(1 /*minimum interval in minutes*/, true /*respect limit*/, newChans, newItems)
-	return f
-}
-
-func (f *fetcher) Fetch() (items []Item, next time.Time, err error) {
-	fmt.Println("fetching", f.uri)
-	if err = f.feed.Fetch(f.uri, nil); err != nil {
-		return
-	}
-	items = f.items
-	f.items = nil
-	next = time.Now().Add(time.Duration(f.feed.SecondsTillUpdate()) * time.Second)
-	return
-}
-
 // TODO: in a longer talk: move the Subscribe function onto a Reader type, to
 // support dynamically adding and removing Subscriptions.  Reader should dedupe.
 
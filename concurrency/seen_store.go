@@ -0,0 +1,395 @@
+package concurrency
+
+import (
+	"bufio"
+	"container/list"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultSeenCapacity bounds the GUIDs a subscription remembers when no
+// SeenStore is given
+const DefaultSeenCapacity = 10000
+
+// SeenStore remembers the GUIDs of the items delivered, Subscribe and
+// Dedupe use it to drop duplicates, a store can be shared by several
+// subscriptions
+// they check Seen when an item is fetched and Add it once it is sent, so
+// an item still pending when they stop is delivered after a restart
+type SeenStore interface {
+	// Add records guid, it reports false if guid was recorded already
+	Add(guid string) bool
+	// Seen reports whether guid was recorded, without recording it, a
+	// recorded guid counts as seen again, as for Add
+	Seen(guid string) bool
+}
+
+// seenEntry is a GUID in a seenLRU
+type seenEntry struct {
+	guid      string
+	seen      time.Time // last time it was added
+	persisted time.Time // seen as written to the log, for FileSeenStore
+}
+
+// seenLRU keeps the most recently seen GUIDs, up to capacity, and drops
+// the ones not seen for ttl, it is not safe for concurrent use
+type seenLRU struct {
+	capacity int
+	ttl      time.Duration
+	order    *list.List // of *seenEntry, most recent first
+	entries  map[string]*list.Element
+}
+
+func newSeenLRU(capacity int, ttl time.Duration) *seenLRU {
+	return &seenLRU{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// add records guid as seen at now, it returns the entry and whether it
+// is new, an expired entry counts as new
+func (l *seenLRU) add(guid string, now time.Time) (*seenEntry, bool) {
+	if e := l.touch(guid, now); e != nil {
+		return e, false
+	}
+
+	e := &seenEntry{guid: guid, seen: now}
+	l.entries[guid] = l.order.PushFront(e)
+
+	if l.capacity > 0 && l.order.Len() > l.capacity {
+		l.remove(l.order.Back())
+	}
+
+	return e, true
+}
+
+// touch marks guid as seen at now if it is recorded, it returns its entry,
+// or nil
+func (l *seenLRU) touch(guid string, now time.Time) *seenEntry {
+	l.expire(now)
+
+	el, ok := l.entries[guid]
+	if !ok {
+		return nil
+	}
+
+	e := el.Value.(*seenEntry)
+	e.seen = now
+	l.order.MoveToFront(el)
+
+	return e
+}
+
+// expire drops the entries not seen for ttl, the oldest are at the back
+func (l *seenLRU) expire(now time.Time) {
+	if l.ttl <= 0 {
+		return
+	}
+
+	for el := l.order.Back(); el != nil; el = l.order.Back() {
+		if now.Sub(el.Value.(*seenEntry).seen) < l.ttl {
+			return
+		}
+
+		l.remove(el)
+	}
+}
+
+func (l *seenLRU) remove(el *list.Element) {
+	l.order.Remove(el)
+	delete(l.entries, el.Value.(*seenEntry).guid)
+}
+
+// MemorySeenStore is a SeenStore in memory, bounded in size and age
+type MemorySeenStore struct {
+	mu  sync.Mutex
+	lru *seenLRU
+}
+
+// NewMemorySeenStore returns a store keeping the capacity GUIDs seen last,
+// and forgetting the ones not seen for ttl, a capacity or ttl of 0 is no
+// bound
+// a GUID still in a feed is seen again at every fetch, so a ttl longer
+// than the fetch interval only forgets items gone from the feed
+func NewMemorySeenStore(capacity int, ttl time.Duration) *MemorySeenStore {
+	return &MemorySeenStore{lru: newSeenLRU(capacity, ttl)}
+}
+
+func (m *MemorySeenStore) Add(guid string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, added := m.lru.add(guid, time.Now())
+
+	return added
+}
+
+func (m *MemorySeenStore) Seen(guid string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.lru.touch(guid, time.Now()) != nil
+}
+
+// Len is the number of GUIDs remembered
+func (m *MemorySeenStore) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.lru.expire(time.Now())
+
+	return m.lru.order.Len()
+}
+
+// minCompact is the number of stale records that trigger a compaction
+const minCompact = 1000
+
+// FileSeenStore is a SeenStore that survives restarts, it keeps the GUIDs
+// in memory like MemorySeenStore and appends them to a log, which is
+// replayed on open and rewritten once stale records pile up
+// a log record is a line with the unix time and the quoted GUID, a torn
+// last line, from a crash, is ignored
+type FileSeenStore struct {
+	mu      sync.Mutex
+	path    string
+	f       *os.File
+	lru     *seenLRU
+	records int   // records in the log
+	err     error // first write error, returned by Close
+}
+
+// OpenFileSeenStore opens, or creates, the log at path, capacity and ttl
+// bound the GUIDs as for NewMemorySeenStore
+func OpenFileSeenStore(path string, capacity int, ttl time.Duration) (*FileSeenStore, error) {
+	s := &FileSeenStore{path: path, lru: newSeenLRU(capacity, ttl)}
+
+	if err := s.replay(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+
+	s.f = f
+
+	return s, nil
+}
+
+// replay loads the log
+func (s *FileSeenStore) replay() error {
+	f, err := os.Open(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	now := time.Now()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(nil, 1<<20)
+
+	for sc.Scan() {
+		guid, seen, ok := parseSeenRecord(sc.Text())
+		if !ok {
+			continue
+		}
+
+		s.records++
+
+		if s.lru.ttl > 0 && now.Sub(seen) >= s.lru.ttl {
+			continue
+		}
+
+		e, _ := s.lru.add(guid, seen)
+		e.persisted = seen
+	}
+
+	if err := sc.Err(); err != nil {
+		return fmt.Errorf("concurrency: read %s: %w", s.path, err)
+	}
+
+	// the adds above went by the times in the log
+	s.lru.expire(now)
+
+	return nil
+}
+
+func parseSeenRecord(line string) (guid string, seen time.Time, ok bool) {
+	ts, quoted, ok := strings.Cut(line, " ")
+	if !ok {
+		return "", time.Time{}, false
+	}
+
+	sec, err := strconv.ParseInt(ts, 10, 64)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	guid, err = strconv.Unquote(quoted)
+	if err != nil {
+		return "", time.Time{}, false
+	}
+
+	return guid, time.Unix(sec, 0), true
+}
+
+func formatSeenRecord(e *seenEntry) string {
+	return strconv.FormatInt(e.seen.Unix(), 10) + " " + strconv.Quote(e.guid) + "\n"
+}
+
+// Add records guid, in memory and in the log, a write error does not fail
+// the Add, it is returned by Close
+func (s *FileSeenStore) Add(guid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	e, added := s.lru.add(guid, now)
+
+	// a GUID seen again is logged again once its record is half way to
+	// expiring, so it does not expire after a restart while still seen
+	if added || s.lru.ttl > 0 && now.Sub(e.persisted) >= s.lru.ttl/2 {
+		s.logLocked(e, now)
+	}
+
+	return added
+}
+
+// Seen reports whether guid is recorded, a hit is logged again as for Add
+func (s *FileSeenStore) Seen(guid string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+
+	e := s.lru.touch(guid, now)
+	if e == nil {
+		return false
+	}
+
+	if s.lru.ttl > 0 && now.Sub(e.persisted) >= s.lru.ttl/2 {
+		s.logLocked(e, now)
+	}
+
+	return true
+}
+
+// logLocked appends the record of e to the log, and compacts it once the
+// stale records pile up
+func (s *FileSeenStore) logLocked(e *seenEntry, now time.Time) {
+	if s.f != nil && s.err == nil {
+		if _, err := s.f.WriteString(formatSeenRecord(e)); err != nil {
+			s.err = err
+		} else {
+			e.persisted = now
+			s.records++
+		}
+	}
+
+	if s.records-s.lru.order.Len() >= max(minCompact, s.lru.order.Len()) {
+		s.compactLocked()
+	}
+}
+
+// Compact rewrites the log with the GUIDs remembered, it happens on its
+// own once the stale records outnumber them
+func (s *FileSeenStore) Compact() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.compactLocked()
+
+	return s.err
+}
+
+// compactLocked writes the live records to a new file and renames it over
+// the log, a crash leaves either log whole
+func (s *FileSeenStore) compactLocked() {
+	if s.err != nil || s.f == nil {
+		return
+	}
+
+	s.lru.expire(time.Now())
+
+	tmp := s.path + ".tmp"
+
+	err := func() error {
+		f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		w := bufio.NewWriter(f)
+
+		// oldest first, so a replay rebuilds the same order
+		for el := s.lru.order.Back(); el != nil; el = el.Prev() {
+			if _, err := w.WriteString(formatSeenRecord(el.Value.(*seenEntry))); err != nil {
+				return err
+			}
+		}
+
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		return f.Sync()
+	}()
+
+	if err == nil {
+		err = os.Rename(tmp, s.path)
+	}
+
+	if err != nil {
+		os.Remove(tmp)
+		s.err = fmt.Errorf("concurrency: compact %s: %w", s.path, err)
+		return
+	}
+
+	// the old handle points at the replaced file
+	s.f.Close()
+
+	s.f, err = os.OpenFile(s.path, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		s.err = err
+		return
+	}
+
+	s.records = s.lru.order.Len()
+
+	for el := s.lru.order.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*seenEntry)
+		e.persisted = e.seen
+	}
+}
+
+// Close closes the log, it returns the first error writing it
+func (s *FileSeenStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.f == nil {
+		return s.err
+	}
+
+	if err := s.f.Close(); err != nil && s.err == nil {
+		s.err = err
+	}
+
+	s.f = nil
+
+	return s.err
+}
@@ -0,0 +1,230 @@
+package concurrency
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+func TestMemorySeenStore(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		m := NewMemorySeenStore(2, time.Hour)
+
+		if !m.Add("a") || !m.Add("b") || m.Add("a") {
+			t.Fatal("a and b must be new once")
+		}
+
+		// c evicts b, a was seen more recently
+		m.Add("c")
+		if m.Add("a") {
+			t.Error("a evicted instead of b")
+		}
+
+		if !m.Add("b") {
+			t.Error("b not evicted")
+		}
+
+		// a and b are seen again 40 minutes in, c is not
+		time.Sleep(40 * time.Minute)
+		m.Add("a")
+		m.Add("b")
+		time.Sleep(30 * time.Minute)
+
+		if m.Len() != 2 {
+			t.Errorf("Len = %d; want 2", m.Len())
+		}
+
+		time.Sleep(31 * time.Minute)
+
+		if m.Len() != 0 || !m.Add("a") {
+			t.Errorf("entries not seen for the ttl did not expire")
+		}
+	})
+}
+
+func TestFileSeenStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.log")
+
+	s, err := OpenFileSeenStore(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, guid := range []string{"a", "with space", "new\nline"} {
+		if !s.Add(guid) {
+			t.Errorf("Add(%q) = false on an empty store", guid)
+		}
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	// a crash while writing leaves a torn line
+	f, _ := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	f.WriteString(`1700000000 "tor`)
+	f.Close()
+
+	s, err = OpenFileSeenStore(path, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	for _, guid := range []string{"a", "with space", "new\nline"} {
+		if s.Add(guid) {
+			t.Errorf("Add(%q) = true after reopening", guid)
+		}
+	}
+
+	if !s.Add("tor") {
+		t.Error("the torn record was loaded")
+	}
+}
+
+func TestFileSeenStoreCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.log")
+
+	// only the last 10 are kept, the log is compacted as it grows
+	s, err := OpenFileSeenStore(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := range 3 * minCompact {
+		s.Add(strconv.Itoa(i))
+	}
+
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	b, _ := os.ReadFile(path)
+	if n := bytes.Count(b, []byte("\n")); n > minCompact+10 {
+		t.Errorf("log has %d records; want it compacted", n)
+	}
+
+	s, err = OpenFileSeenStore(path, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.Compact(); err != nil {
+		t.Fatalf("Compact: %v", err)
+	}
+
+	b, _ = os.ReadFile(path)
+	if n := bytes.Count(b, []byte("\n")); n != 10 {
+		t.Errorf("log has %d records after Compact; want 10", n)
+	}
+
+	last := strconv.Itoa(3*minCompact - 1)
+	if s.Add(last) {
+		t.Errorf("the last GUID was lost")
+	}
+
+	if !s.Add("0") {
+		t.Errorf("an evicted GUID is still remembered")
+	}
+
+	s.Close()
+}
+
+func TestSubscribeSeenStoreRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.log")
+
+	run := func() []Item {
+		store, err := OpenFileSeenStore(path, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		var got []Item
+
+		synctest.Test(t, func(t *testing.T) {
+			f := &scriptedFetcher{}
+			s := SubscribeContext(context.Background(), f, WithSeenStore(store))
+
+			// the item comes at most once, within the first fetch
+			select {
+			case it := <-s.Updates():
+				got = append(got, it)
+			case <-time.After(time.Minute):
+			}
+
+			s.Close()
+		})
+
+		return got
+	}
+
+	if got := run(); len(got) != 1 {
+		t.Fatalf("first run got %v; want the item", got)
+	}
+
+	if got := run(); len(got) != 0 {
+		t.Errorf("after a restart got %v again", got)
+	}
+}
+
+func TestSubscribeSeenStorePending(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "seen.log")
+
+	// run receives n items and closes the subscription with the others
+	// still pending
+	run := func(n int) []string {
+		store, err := OpenFileSeenStore(path, 0, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer store.Close()
+
+		var got []string
+
+		synctest.Test(t, func(t *testing.T) {
+			s := Subscribe(fetcherFunc(func() ([]Item, time.Time, error) {
+				return []Item{{GUID: "a"}, {GUID: "b"}, {GUID: "c"}}, time.Now().Add(time.Hour), nil
+			}), WithSeenStore(store))
+
+			for range n {
+				got = append(got, (<-s.Updates()).GUID)
+			}
+
+			synctest.Wait()
+			s.Close()
+		})
+
+		return got
+	}
+
+	if got := run(1); len(got) != 1 || got[0] != "a" {
+		t.Fatalf("first run got %v; want [a]", got)
+	}
+
+	// b and c were pending, not delivered, they come after a restart
+	if got := run(2); len(got) != 2 || got[0] != "b" || got[1] != "c" {
+		t.Errorf("after a restart got %v; want [b c]", got)
+	}
+}
+
+func TestSeenStoreSeen(t *testing.T) {
+	m := NewMemorySeenStore(0, 0)
+
+	if m.Seen("a") {
+		t.Error("Seen of an unknown GUID = true")
+	}
+
+	if !m.Add("a") {
+		t.Error("Seen recorded the GUID")
+	}
+
+	if !m.Seen("a") {
+		t.Error("Seen of an added GUID = false")
+	}
+}
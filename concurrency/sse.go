@@ -0,0 +1,346 @@
+package concurrency
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaults of the SSE broker
+const (
+	DefaultSSEReplay       = 100
+	DefaultSSEHeartbeat    = 15 * time.Second
+	DefaultSSEClientBuffer = 16
+	DefaultSSEIdleTimeout  = 30 * time.Second
+)
+
+// sseRetry is the reconnection delay sent to clients, in milliseconds, it
+// is well below DefaultSSEIdleTimeout so a dropped client comes back in time
+const sseRetry = 2000
+
+type SSEOption func(*SSEBroker)
+
+// WithSSEIdleTimeout sets how long the broker waits, after its last client
+// disconnected, before it closes the subscription, 0 or less keeps the
+// subscription open until Close
+func WithSSEIdleTimeout(d time.Duration) SSEOption {
+	return func(b *SSEBroker) {
+		b.idleTimeout = d
+	}
+}
+
+// WithSSEReplay sets how many recent items are kept for clients resuming
+// with Last-Event-ID
+func WithSSEReplay(n int) SSEOption {
+	return func(b *SSEBroker) {
+		b.replaySize = n
+	}
+}
+
+// WithSSEHeartbeat sets the interval of the heartbeat comments that keep
+// idle connections open through proxies
+func WithSSEHeartbeat(d time.Duration) SSEOption {
+	return func(b *SSEBroker) {
+		b.heartbeat = d
+	}
+}
+
+// WithSSEClientBuffer sets how many items a client may fall behind before
+// it is disconnected
+func WithSSEClientBuffer(n int) SSEOption {
+	return func(b *SSEBroker) {
+		b.clientBuffer = n
+	}
+}
+
+// sseEvent is an item with the id clients resume from
+type sseEvent struct {
+	id   uint64
+	item Item
+}
+
+// sseClient is a connected client, events is closed when the client is
+// dropped for falling behind
+type sseClient struct {
+	events chan sseEvent
+}
+
+// SSEBroker streams the items of one Subscription to many clients as
+// server-sent events, it is an http.Handler
+// every client has a buffer of its own, a client that falls behind by
+// more than the buffer is disconnected instead of slowing the others down,
+// the browser reconnects with Last-Event-ID and the missed items are
+// replayed, as long as they are still among the recent ones kept, an ID
+// of another broker, like the one before a restart, replays all of them
+// once the last client disconnected and none came back within the idle
+// timeout the subscription is closed, later requests get 204 No Content,
+// which tells browsers to stop reconnecting
+// to stream several feeds, pass a Merge of their subscriptions
+type SSEBroker struct {
+	src Subscription
+
+	replaySize   int
+	heartbeat    time.Duration
+	clientBuffer int
+	idleTimeout  time.Duration
+
+	// event IDs are epoch-seq, a new broker, like one of a restarted
+	// server, has a new epoch, so the IDs of the old one are told apart
+	epoch string
+
+	mu      sync.Mutex
+	lastID  uint64
+	recent  []sseEvent // the last replaySize events, oldest first
+	clients map[*sseClient]struct{}
+	idle    *time.Timer // runs while no client is connected
+
+	done      chan struct{} // closed by stop
+	stopOnce  sync.Once
+	closeOnce sync.Once
+	closeErr  error
+}
+
+// NewSSEBroker starts streaming the items of src, Close stops it and closes
+// src
+func NewSSEBroker(src Subscription, opts ...SSEOption) *SSEBroker {
+	b := &SSEBroker{
+		src:          src,
+		epoch:        strconv.FormatInt(time.Now().UnixNano(), 36),
+		replaySize:   DefaultSSEReplay,
+		heartbeat:    DefaultSSEHeartbeat,
+		clientBuffer: DefaultSSEClientBuffer,
+		idleTimeout:  DefaultSSEIdleTimeout,
+		clients:      make(map[*sseClient]struct{}),
+		done:         make(chan struct{}),
+	}
+
+	for _, opt := range opts {
+		opt(b)
+	}
+
+	go b.loop()
+
+	return b
+}
+
+// loop hands every item of the subscription to the clients, it never
+// blocks on a client
+func (b *SSEBroker) loop() {
+	// the subscription ended, so do the streams
+	defer b.stop()
+
+	for it := range b.src.Updates() {
+		b.mu.Lock()
+
+		b.lastID++
+		ev := sseEvent{id: b.lastID, item: it}
+
+		if b.replaySize > 0 {
+			if len(b.recent) == b.replaySize {
+				b.recent = b.recent[1:]
+			}
+
+			b.recent = append(b.recent, ev)
+		}
+
+		for c := range b.clients {
+			select {
+			case c.events <- ev:
+			default:
+				// too slow, the client resumes from its last event
+				b.remove(c)
+			}
+		}
+
+		b.mu.Unlock()
+	}
+}
+
+// subscribe registers a client, it returns the events after lastID still
+// kept, the registration and the replay are atomic so no event is lost or
+// sent twice, ok is false once the broker is closed
+func (b *SSEBroker) subscribe(lastID uint64) (c *sseClient, replay []sseEvent, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	select {
+	case <-b.done:
+		return nil, nil, false
+	default:
+	}
+
+	if b.idle != nil {
+		b.idle.Stop()
+		b.idle = nil
+	}
+
+	// an ID not sent yet cannot be trusted, the whole buffer is replayed
+	if lastID > b.lastID {
+		lastID = 0
+	}
+
+	for _, ev := range b.recent {
+		if ev.id > lastID {
+			replay = append(replay, ev)
+		}
+	}
+
+	c = &sseClient{events: make(chan sseEvent, b.clientBuffer)}
+	b.clients[c] = struct{}{}
+
+	return c, replay, true
+}
+
+// unsubscribe removes the client, if it was not dropped already
+func (b *SSEBroker) unsubscribe(c *sseClient) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if _, ok := b.clients[c]; ok {
+		b.remove(c)
+	}
+}
+
+// remove drops a registered client and starts the idle timer after the
+// last one, b.mu is held
+func (b *SSEBroker) remove(c *sseClient) {
+	delete(b.clients, c)
+	close(c.events)
+
+	if len(b.clients) == 0 && b.idleTimeout > 0 && b.idle == nil {
+		b.idle = time.AfterFunc(b.idleTimeout, func() { b.Close() })
+	}
+}
+
+// ServeHTTP streams the items to the client until it disconnects or the
+// broker is closed
+func (b *SSEBroker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+
+	// the last event the client saw, browsers send it on reconnect, an ID
+	// of another epoch resumes from the oldest event kept
+	c, replay, ok := b.subscribe(b.parseID(r.Header.Get("Last-Event-ID")))
+	if !ok {
+		// the stream is over, browsers do not reconnect after a 204
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	defer b.unsubscribe(c)
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	// tell nginx not to buffer the stream
+	h.Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+
+	if _, err := fmt.Fprintf(w, "retry: %d\n\n", sseRetry); err != nil {
+		return
+	}
+
+	for _, ev := range replay {
+		if b.writeSSEEvent(w, ev) != nil {
+			return
+		}
+	}
+
+	if err := rc.Flush(); err != nil {
+		// without flushing the events would never reach the client
+		return
+	}
+
+	var heartbeat <-chan time.Time
+	if b.heartbeat > 0 {
+		t := time.NewTicker(b.heartbeat)
+		defer t.Stop()
+		heartbeat = t.C
+	}
+
+	for {
+		var err error
+
+		select {
+		case ev, ok := <-c.events:
+			if !ok {
+				// dropped for falling behind
+				return
+			}
+
+			err = b.writeSSEEvent(w, ev)
+		case <-heartbeat:
+			// a comment, ignored by the client
+			_, err = fmt.Fprint(w, ": heartbeat\n\n")
+		case <-r.Context().Done():
+			return
+		case <-b.done:
+			return
+		}
+
+		if err == nil {
+			err = rc.Flush()
+		}
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// parseID returns the sequence of an event ID of this broker, 0 for any
+// other ID
+func (b *SSEBroker) parseID(v string) uint64 {
+	epoch, seq, ok := strings.Cut(v, "-")
+	if !ok || epoch != b.epoch {
+		return 0
+	}
+
+	id, err := strconv.ParseUint(seq, 10, 64)
+	if err != nil {
+		return 0
+	}
+
+	return id
+}
+
+// writeSSEEvent writes an item event, the data is the item as JSON, which
+// has no newlines so it fits in one data line
+func (b *SSEBroker) writeSSEEvent(w http.ResponseWriter, ev sseEvent) error {
+	data, err := json.Marshal(ev.item)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %s-%d\nevent: item\ndata: %s\n\n", b.epoch, ev.id, data)
+
+	return err
+}
+
+// Close closes the subscription, ends every stream and returns the error
+// of the subscription's Close
+func (b *SSEBroker) Close() error {
+	b.closeOnce.Do(func() {
+		b.stop()
+		b.closeErr = b.src.Close()
+	})
+
+	return b.closeErr
+}
+
+// stop ends every stream and refuses new ones, the subscription is left
+// as is
+func (b *SSEBroker) stop() {
+	b.stopOnce.Do(func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+
+		close(b.done)
+
+		if b.idle != nil {
+			b.idle.Stop()
+		}
+	})
+}
@@ -0,0 +1,210 @@
+package concurrency
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// chanSub is a Subscription fed by the test
+type chanSub struct {
+	updates chan Item
+	closed  chan struct{}
+}
+
+func newChanSub() *chanSub {
+	return &chanSub{updates: make(chan Item), closed: make(chan struct{})}
+}
+
+func (s *chanSub) Updates() <-chan Item { return s.updates }
+
+func (s *chanSub) Close() error {
+	close(s.closed)
+	close(s.updates)
+	return nil
+}
+
+// readEvent reads one event, skipping comments and the retry field, and
+// returns its id and data
+func readEvent(t *testing.T, r *bufio.Reader) (id, data string) {
+	t.Helper()
+
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read event: %v", err)
+		}
+
+		line = strings.TrimSuffix(line, "\n")
+
+		switch {
+		case line == "":
+			if data != "" {
+				return id, data
+			}
+		case strings.HasPrefix(line, "id: "):
+			id = strings.TrimPrefix(line, "id: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+}
+
+// seqOf is the sequence of an event ID, after the epoch
+func seqOf(id string) string {
+	_, seq, _ := strings.Cut(id, "-")
+	return seq
+}
+
+func TestSSEBroker(t *testing.T) {
+	src := newChanSub()
+	b := NewSSEBroker(src, WithSSEReplay(2), WithSSEIdleTimeout(0))
+	defer b.Close()
+
+	ts := httptest.NewServer(b)
+	defer ts.Close()
+
+	connect := func(lastID string) (*http.Response, *bufio.Reader) {
+		req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+		if lastID != "" {
+			req.Header.Set("Last-Event-ID", lastID)
+		}
+
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("connect: %v", err)
+		}
+
+		if ct := res.Header.Get("Content-Type"); ct != "text/event-stream" {
+			t.Fatalf("Content-Type = %q", ct)
+		}
+
+		return res, bufio.NewReader(res.Body)
+	}
+
+	res1, r1 := connect("")
+	defer res1.Body.Close()
+	res2, r2 := connect("")
+	defer res2.Body.Close()
+
+	// both are registered once the headers arrived
+	for i := range 3 {
+		src.updates <- Item{Title: "t", GUID: string(rune('a' + i))}
+	}
+
+	for _, r := range []*bufio.Reader{r1, r2} {
+		for i, want := range []string{`"guid":"a"`, `"guid":"b"`, `"guid":"c"`} {
+			id, data := readEvent(t, r)
+			if seqOf(id) != string(rune('1'+i)) || !strings.Contains(data, want) {
+				t.Errorf("event = %s %s; want id %d with %s", id, data, i+1, want)
+			}
+		}
+	}
+
+	// only the last two are kept, the first is lost for a client this far
+	// behind
+	res3, r3 := connect(b.epoch + "-0")
+	defer res3.Body.Close()
+
+	for _, want := range []string{"2", "3"} {
+		if id, _ := readEvent(t, r3); seqOf(id) != want {
+			t.Errorf("replayed id = %s; want %s", id, want)
+		}
+	}
+
+	// an ID of the broker before a restart, far ahead of this one, and one
+	// not sent yet replay all the events kept
+	for _, lastID := range []string{"lz0k1-500", "500", b.epoch + "-500"} {
+		res, r := connect(lastID)
+
+		for _, want := range []string{"2", "3"} {
+			if id, _ := readEvent(t, r); id != b.epoch+"-"+want {
+				t.Errorf("Last-Event-ID %s: replayed id = %s; want %s-%s", lastID, id, b.epoch, want)
+			}
+		}
+
+		res.Body.Close()
+	}
+
+	if err := b.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	if _, err := r1.ReadString('\n'); err == nil {
+		// the stream may still hold the tail of the last event
+		if _, err := r1.ReadString('\n'); err == nil {
+			t.Errorf("stream still open after Close")
+		}
+	}
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	if res.StatusCode != http.StatusNoContent {
+		t.Errorf("status after Close = %d; want 204", res.StatusCode)
+	}
+}
+
+func TestSSEBrokerSlowClient(t *testing.T) {
+	src := newChanSub()
+	b := NewSSEBroker(src, WithSSEClientBuffer(1), WithSSEIdleTimeout(0))
+	defer b.Close()
+
+	slow, _, _ := b.subscribe(0)
+	fast, _, _ := b.subscribe(0)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range fast.events {
+		}
+	}()
+
+	// the slow client never reads, it must not hold up the others
+	for range 3 {
+		select {
+		case src.updates <- Item{}:
+		case <-time.After(time.Second):
+			t.Fatal("broker blocked on the slow client")
+		}
+	}
+
+	// dropped, its buffer is drained then the channel is closed
+	n := 0
+	for range slow.events {
+		n++
+	}
+
+	if n != 1 {
+		t.Errorf("slow client got %d events; want 1", n)
+	}
+
+	b.unsubscribe(fast)
+	<-done
+}
+
+func TestSSEBrokerIdle(t *testing.T) {
+	src := newChanSub()
+	b := NewSSEBroker(src, WithSSEIdleTimeout(10*time.Millisecond))
+
+	ts := httptest.NewServer(b)
+	defer ts.Close()
+
+	res, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	select {
+	case <-src.closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("subscription not closed after the last client left")
+	}
+}
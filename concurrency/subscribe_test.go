@@ -0,0 +1,276 @@
+package concurrency
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// blockingFetcher returns an item on its first fetch, later fetches block
+// until their context is done
+type blockingFetcher struct {
+	fetches  int
+	canceled int
+}
+
+func (f *blockingFetcher) FetchContext(ctx context.Context) ([]Item, time.Time, error) {
+	f.fetches++
+	if f.fetches == 1 {
+		return []Item{{Title: "first", GUID: "1"}}, time.Now(), nil
+	}
+
+	<-ctx.Done()
+	f.canceled++
+
+	return nil, time.Time{}, ctx.Err()
+}
+
+func TestSubscribeContextClose(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		f := &blockingFetcher{}
+		s := SubscribeContext(context.Background(), f)
+
+		if it := <-s.Updates(); it.GUID != "1" {
+			t.Fatalf("item = %+v; want the first one", it)
+		}
+
+		// the second fetch is in flight
+		synctest.Wait()
+		if f.fetches != 2 {
+			t.Fatalf("fetches = %d; want 2", f.fetches)
+		}
+
+		if err := s.Close(); err != nil {
+			t.Errorf("Close = %v; want nil, a canceled fetch is no error", err)
+		}
+
+		// Close waited for the fetch to return
+		if f.canceled != 1 {
+			t.Errorf("canceled fetches = %d; want 1", f.canceled)
+		}
+
+		if _, ok := <-s.Updates(); ok {
+			t.Error("Updates not closed")
+		}
+	})
+}
+
+func TestSubscribeContextCancel(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+
+		f := &blockingFetcher{}
+		s := SubscribeContext(ctx, f)
+		<-s.Updates()
+
+		cancel()
+
+		if _, ok := <-s.Updates(); ok {
+			t.Error("Updates not closed after the context was canceled")
+		}
+
+		if f.canceled != 1 {
+			t.Errorf("canceled fetches = %d; want 1", f.canceled)
+		}
+
+		// Close still works, and does not block
+		if err := s.Close(); err != nil {
+			t.Errorf("Close = %v", err)
+		}
+	})
+}
+
+// slowFetcher is a plain Fetcher that takes an hour
+type slowFetcher struct{}
+
+func (slowFetcher) Fetch() ([]Item, time.Time, error) {
+	time.Sleep(time.Hour)
+	return []Item{{GUID: "late"}}, time.Now().Add(time.Hour), nil
+}
+
+func TestWithContext(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		start := time.Now()
+
+		_, _, err := WithContext(slowFetcher{}).FetchContext(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("FetchContext = %v; want DeadlineExceeded", err)
+		}
+
+		if d := time.Since(start); d != time.Minute {
+			t.Errorf("FetchContext returned after %v; want 1m", d)
+		}
+
+		// the plain fetch goes on until it finishes on its own
+		time.Sleep(time.Hour)
+	})
+}
+
+func TestSubscribeWithError(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		wantErr := errors.New("feed down")
+
+		s := Subscribe(fetcherFunc(func() ([]Item, time.Time, error) {
+			return nil, time.Time{}, wantErr
+		}))
+
+		// the failed fetch is retried in 10s, Close reports it
+		time.Sleep(time.Second)
+
+		if err := s.Close(); !errors.Is(err, wantErr) {
+			t.Errorf("Close = %v; want %v", err, wantErr)
+		}
+	})
+}
+
+type fetcherFunc func() ([]Item, time.Time, error)
+
+func (f fetcherFunc) Fetch() ([]Item, time.Time, error) { return f() }
+
+// scriptedFetcher returns the errors in order, then items
+type scriptedFetcher struct {
+	errs []error
+}
+
+func (f *scriptedFetcher) FetchContext(ctx context.Context) ([]Item, time.Time, error) {
+	if len(f.errs) > 0 {
+		err := f.errs[0]
+		f.errs = f.errs[1:]
+		return nil, time.Time{}, err
+	}
+
+	return []Item{{GUID: "ok"}}, time.Now().Add(time.Hour), nil
+}
+
+func TestSubscribeBackoff(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		down := errors.New("feed down")
+
+		s := SubscribeContext(context.Background(),
+			&scriptedFetcher{errs: []error{down, down, down}},
+			WithBackoff(Backoff{Initial: time.Second, Max: 3 * time.Second, Multiplier: 2}),
+			WithErrorHistory(2),
+			WithFailingAfter(2),
+		).(StatsSubscription)
+		defer s.Close()
+
+		start := time.Now()
+
+		// fails at 0s, retried after 1s, 2s, then 3s instead of 4s
+		wantHealth := []Health{Degraded, Failing, Failing}
+		for i, wait := range []time.Duration{0, time.Second, 2 * time.Second} {
+			time.Sleep(wait)
+			synctest.Wait()
+
+			st := s.Stats()
+			if st.ConsecutiveFailures != i+1 || st.Health != wantHealth[i] {
+				t.Fatalf("after failure %d: %d in a row, %v; want %d, %v", i+1, st.ConsecutiveFailures, st.Health, i+1, wantHealth[i])
+			}
+		}
+
+		st := s.Stats()
+		if len(st.Errors) != 2 || !errors.Is(st.Errors[1].Err, down) {
+			t.Errorf("Errors = %v; want the last 2", st.Errors)
+		}
+
+		if d := st.NextFetch.Sub(start); d != 6*time.Second {
+			t.Errorf("next fetch at %v; want 6s, the capped backoff", d)
+		}
+
+		if it := <-s.Updates(); it.GUID != "ok" || time.Since(start) != 6*time.Second {
+			t.Errorf("got %+v after %v; want the item after 6s", it, time.Since(start))
+		}
+
+		st = s.Stats()
+		if st.Health != Healthy || st.ConsecutiveFailures != 0 || st.Fetches != 4 || st.Failures != 3 {
+			t.Errorf("stats after recovery = %+v", st)
+		}
+	})
+}
+
+func TestSubscribeGone(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		s := Subscribe(fetcherFunc(func() ([]Item, time.Time, error) {
+			return nil, time.Time{}, ErrGone
+		})).(StatsSubscription)
+
+		// the subscription ends by itself
+		if _, ok := <-s.Updates(); ok {
+			t.Fatal("Updates not closed")
+		}
+
+		if err := s.Close(); !errors.Is(err, ErrGone) {
+			t.Errorf("Close = %v; want ErrGone", err)
+		}
+
+		if st := s.Stats(); st.Health != Failing || !errors.Is(st.Err, ErrGone) || st.Fetches != 1 {
+			t.Errorf("stats = %+v; want failing on ErrGone after 1 fetch", st)
+		}
+	})
+}
+
+func TestMergeGone(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		gone := Subscribe(fetcherFunc(func() ([]Item, time.Time, error) {
+			return nil, time.Time{}, ErrGone
+		}))
+		live := newChanSub()
+
+		m := Merge(gone, live)
+
+		// the ended subscription sends nothing, not even zero Items
+		go func() { live.updates <- Item{GUID: "live"} }()
+		if it := <-m.Updates(); it.GUID != "live" {
+			t.Fatalf("got %+v; want the item of the live subscription", it)
+		}
+
+		synctest.Wait()
+		select {
+		case it := <-m.Updates():
+			t.Fatalf("got %+v after the feed was gone", it)
+		default:
+		}
+
+		if err := m.Close(); !errors.Is(err, ErrGone) {
+			t.Errorf("Close = %v; want ErrGone", err)
+		}
+	})
+}
+
+func TestBackoffDelay(t *testing.T) {
+	b := Backoff{Initial: time.Second, Max: time.Minute, Multiplier: 3, Jitter: 0.5}
+
+	for failures, base := range map[int]time.Duration{1: time.Second, 2: 3 * time.Second, 3: 9 * time.Second, 10: time.Minute} {
+		for range 100 {
+			d := b.Delay(failures)
+			if d < base/2 || d > base*3/2 || d > time.Minute {
+				t.Fatalf("Delay(%d) = %v; want %v ±50%%, at most 1m", failures, d, base)
+			}
+		}
+	}
+}
+
+func TestBackoffDelayZero(t *testing.T) {
+	// a zero Backoff falls back to the default initial wait, uncapped
+	var b Backoff
+
+	if d := b.Delay(1); d != DefaultBackoff.Initial {
+		t.Errorf("Delay(1) = %v; want %v", d, DefaultBackoff.Initial)
+	}
+
+	// Initial alone doubles without a cap
+	b = Backoff{Initial: time.Second}
+
+	if d := b.Delay(12); d != 2048*time.Second {
+		t.Errorf("Delay(12) = %v; want 2048s", d)
+	}
+
+	if d := b.Delay(1000); d <= 0 {
+		t.Errorf("Delay(1000) = %v; want a long wait, not an overflow", d)
+	}
+}
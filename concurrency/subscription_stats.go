@@ -0,0 +1,222 @@
+package concurrency
+
+import (
+	"errors"
+	"math"
+	"math/rand"
+	"slices"
+	"time"
+)
+
+// ErrGone marks a feed removed for good, like one answering 410 Gone, the
+// subscription stops fetching it and ends with the error
+var ErrGone = errors.New("concurrency: feed gone")
+
+// Backoff is the wait before fetching a failing feed again, it grows by
+// Multiplier with each failure in a row, from Initial up to Max
+type Backoff struct {
+	Initial    time.Duration // DefaultBackoff.Initial when 0 or less
+	Max        time.Duration // no cap when 0 or less
+	Multiplier float64 // 2 when below 1
+	Jitter     float64 // fraction of the wait randomized, 0.2 is ±20%
+}
+
+// DefaultBackoff doubles the wait from 10 seconds up to 30 minutes
+var DefaultBackoff = Backoff{
+	Initial:    10 * time.Second,
+	Max:        30 * time.Minute,
+	Multiplier: 2,
+	Jitter:     0.2,
+}
+
+// Delay is the wait after the given number of failures in a row
+func (b Backoff) Delay(failures int) time.Duration {
+	if failures < 1 {
+		return 0
+	}
+
+	m := b.Multiplier
+	if m < 1 {
+		m = 2
+	}
+
+	// a zero field must not turn the backoff off, and refetch a failing
+	// feed in a tight loop
+	initial := b.Initial
+	if initial <= 0 {
+		initial = DefaultBackoff.Initial
+	}
+
+	// without a cap it stops short of overflowing a Duration
+	limit := float64(b.Max)
+	if b.Max <= 0 {
+		limit = math.MaxInt64 / 2
+	}
+
+	d := min(float64(initial)*math.Pow(m, float64(failures-1)), limit)
+
+	// the jitter spreads out subscriptions that failed together
+	if b.Jitter > 0 {
+		d += d * b.Jitter * (2*rand.Float64() - 1)
+	}
+
+	return time.Duration(min(d, limit))
+}
+
+// Health of a subscription
+type Health int
+
+const (
+	// Healthy subscriptions fetched successfully last time
+	Healthy Health = iota
+	// Degraded subscriptions failed fewer times in a row than the
+	// threshold of WithFailingAfter
+	Degraded
+	// Failing subscriptions failed at least that many times in a row, or
+	// stopped on ErrGone
+	Failing
+)
+
+func (h Health) String() string {
+	switch h {
+	case Healthy:
+		return "healthy"
+	case Degraded:
+		return "degraded"
+	case Failing:
+		return "failing"
+	default:
+		return "unknown"
+	}
+}
+
+// FetchError is a failed fetch
+type FetchError struct {
+	Time time.Time
+	Err  error
+}
+
+// SubscriptionStats describes the fetches of a subscription
+type SubscriptionStats struct {
+	Health              Health
+	Fetches             int // fetches done, failed ones included
+	Failures            int
+	ConsecutiveFailures int
+	LastSuccess         time.Time
+	LastFailure         time.Time
+	NextFetch           time.Time
+	// Errors are the last failures, oldest first
+	Errors []FetchError
+	// Err is the terminal error the subscription stopped on, if any
+	Err error
+}
+
+// StatsSubscription is a Subscription that reports its stats, the ones
+// of Subscribe and SubscribeContext do
+type StatsSubscription interface {
+	Subscription
+	Stats() SubscriptionStats
+}
+
+// defaults of the subscription options
+const (
+	DefaultErrorHistory = 10
+	DefaultFailingAfter = 3
+)
+
+type SubscribeOption func(*sub)
+
+// WithBackoff sets the backoff of a failing feed, DefaultBackoff by default
+func WithBackoff(b Backoff) SubscribeOption {
+	return func(s *sub) {
+		s.backoff = b
+	}
+}
+
+// WithErrorHistory sets how many of the last errors Stats keeps
+func WithErrorHistory(n int) SubscribeOption {
+	return func(s *sub) {
+		s.errorHistory = n
+	}
+}
+
+// WithFailingAfter sets the failures in a row that make a subscription
+// Failing, fewer make it Degraded
+func WithFailingAfter(n int) SubscribeOption {
+	return func(s *sub) {
+		s.failingAfter = n
+	}
+}
+
+// WithSeenStore sets the store of the GUIDs seen, a FileSeenStore keeps
+// them across restarts, the caller closes it after the subscription, by
+// default a subscription remembers the last DefaultSeenCapacity GUIDs
+func WithSeenStore(store SeenStore) SubscribeOption {
+	return func(s *sub) {
+		s.seen = store
+	}
+}
+
+// Stats returns the stats of the subscription, it is safe to call from
+// any goroutine
+func (s *sub) Stats() SubscriptionStats {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	st := s.stats
+	st.Errors = slices.Clone(st.Errors)
+
+	return st
+}
+
+// recordSuccess counts a successful fetch
+func (s *sub) recordSuccess(next time.Time) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	s.stats.Fetches++
+	s.stats.ConsecutiveFailures = 0
+	s.stats.LastSuccess = time.Now()
+	s.stats.NextFetch = next
+	s.stats.Health = Healthy
+}
+
+// recordFailure counts a failed fetch and returns when to fetch again,
+// after the backoff, a terminal failure is never fetched again
+func (s *sub) recordFailure(err error, terminal bool) (next time.Time) {
+	s.statsMu.Lock()
+	defer s.statsMu.Unlock()
+
+	now := time.Now()
+
+	s.stats.Fetches++
+	s.stats.Failures++
+	s.stats.ConsecutiveFailures++
+	s.stats.LastFailure = now
+
+	if !terminal {
+		next = now.Add(s.backoff.Delay(s.stats.ConsecutiveFailures))
+	}
+
+	s.stats.NextFetch = next
+
+	if s.errorHistory > 0 {
+		if len(s.stats.Errors) == s.errorHistory {
+			s.stats.Errors = s.stats.Errors[1:]
+		}
+
+		s.stats.Errors = append(s.stats.Errors, FetchError{Time: now, Err: err})
+	}
+
+	switch {
+	case terminal:
+		s.stats.Err = err
+		s.stats.Health = Failing
+	case s.stats.ConsecutiveFailures >= s.failingAfter:
+		s.stats.Health = Failing
+	default:
+		s.stats.Health = Degraded
+	}
+
+	return next
+}
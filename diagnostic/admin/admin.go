@@ -0,0 +1,381 @@
+/*
+package admin serves the diagnostics of a process, pprof, runtime metrics,
+goroutine dumps, GC controls, the log level and the build info
+
+it is meant for a listener of its own, like optionex.WithAdminServer,
+every request must authenticate with a bearer token or a client certificate
+
+net/http/pprof registers its handlers on http.DefaultServeMux when it is
+imported, and this package imports it, never serve DefaultServeMux publicly
+*/
+package admin
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	"runtime/debug"
+	"runtime/metrics"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// ErrNoAuth is returned by New when no authentication was configured,
+// the handler never serves without it
+var ErrNoAuth = errors.New("admin: no authentication configured, use WithToken or WithClientCert")
+
+type Option func(*config)
+
+type config struct {
+	token      string
+	clientCert bool
+	// allowed names of client certificates, empty allows any verified one
+	certNames []string
+	level     *slog.LevelVar
+}
+
+// WithToken accepts requests carrying the token as
+// "Authorization: Bearer <token>"
+func WithToken(token string) Option {
+	return func(c *config) {
+		c.token = token
+	}
+}
+
+// WithClientCert accepts requests over a TLS connection with a verified
+// client certificate, the listener must request and verify them, like
+// optionex.WithClientCA does
+// with names, the certificate's common name or one of its DNS names must
+// be among them
+func WithClientCert(names ...string) Option {
+	return func(c *config) {
+		c.clientCert = true
+		c.certNames = names
+	}
+}
+
+// WithLogLevel exposes the level of the loggers using level at
+// /debug/loglevel, to be changed at runtime
+func WithLogLevel(level *slog.LevelVar) Option {
+	return func(c *config) {
+		c.level = level
+	}
+}
+
+// New returns the admin handler, it serves
+//
+//	/debug/pprof/       the pprof profiles
+//	/debug/metrics      runtime/metrics as JSON, ?name= selects metrics
+//	/debug/goroutines   a dump of all goroutine stacks
+//	/debug/gc           GET the GC settings, POST gc_percent and
+//	                    memory_limit to change them, POST /debug/gc/run
+//	                    to run a collection
+//	/debug/loglevel     GET the log level, POST level to change it
+//	/debug/buildinfo    the module and build settings of the binary
+//
+// a request that fails authentication gets 401 Unauthorized
+// a request with both a token and a client certificate configured is
+// accepted if either of them is valid
+func New(opts ...Option) (http.Handler, error) {
+	cfg := &config{}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	if cfg.token == "" && !cfg.clientCert {
+		return nil, ErrNoAuth
+	}
+
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	mux.HandleFunc("GET /debug/metrics", serveMetrics)
+	mux.HandleFunc("GET /debug/goroutines", serveGoroutines)
+	mux.HandleFunc("GET /debug/gc", serveGC)
+	mux.HandleFunc("POST /debug/gc", setGC)
+	mux.HandleFunc("POST /debug/gc/run", runGC)
+	mux.HandleFunc("GET /debug/buildinfo", serveBuildInfo)
+
+	if cfg.level != nil {
+		mux.HandleFunc("GET /debug/loglevel", cfg.serveLogLevel)
+		mux.HandleFunc("POST /debug/loglevel", cfg.setLogLevel)
+	}
+
+	return cfg.authenticate(mux), nil
+}
+
+// authenticate rejects requests without a valid token or client
+// certificate
+func (c *config) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !c.validToken(r) && !c.validCert(r) {
+			if c.token != "" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="admin"`)
+			}
+
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+func (c *config) validToken(r *http.Request) bool {
+	if c.token == "" {
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+
+	// constant time, so the response time does not leak the token
+	return subtle.ConstantTimeCompare([]byte(token), []byte(c.token)) == 1
+}
+
+func (c *config) validCert(r *http.Request) bool {
+	// only chains verified against the client CAs count, a presented but
+	// unverified certificate proves nothing
+	if !c.clientCert || r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+		return false
+	}
+
+	if len(c.certNames) == 0 {
+		return true
+	}
+
+	leaf := r.TLS.VerifiedChains[0][0]
+
+	if slices.Contains(c.certNames, leaf.Subject.CommonName) {
+		return true
+	}
+
+	for _, name := range leaf.DNSNames {
+		if slices.Contains(c.certNames, name) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeJSON writes v as indented JSON
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	enc.Encode(v)
+}
+
+// float is a float64 that encodes NaN and the infinities, which JSON has
+// no numbers for, as strings
+type float float64
+
+func (f float) MarshalJSON() ([]byte, error) {
+	v := float64(f)
+
+	switch {
+	case math.IsNaN(v):
+		return []byte(`"NaN"`), nil
+	case math.IsInf(v, 1):
+		return []byte(`"+Inf"`), nil
+	case math.IsInf(v, -1):
+		return []byte(`"-Inf"`), nil
+	}
+
+	return json.Marshal(v)
+}
+
+// histogram is the JSON form of a metrics.Float64Histogram
+type histogram struct {
+	Counts  []uint64 `json:"counts"`
+	Buckets []float  `json:"buckets"`
+}
+
+// serveMetrics reads runtime/metrics, all of them or the ones named by
+// the name query parameters
+func serveMetrics(w http.ResponseWriter, r *http.Request) {
+	names := r.URL.Query()["name"]
+
+	var samples []metrics.Sample
+
+	for _, d := range metrics.All() {
+		if len(names) == 0 || slices.Contains(names, d.Name) {
+			samples = append(samples, metrics.Sample{Name: d.Name})
+		}
+	}
+
+	metrics.Read(samples)
+
+	out := make(map[string]any, len(samples))
+
+	for _, s := range samples {
+		switch s.Value.Kind() {
+		case metrics.KindUint64:
+			out[s.Name] = s.Value.Uint64()
+		case metrics.KindFloat64:
+			out[s.Name] = float(s.Value.Float64())
+		case metrics.KindFloat64Histogram:
+			h := s.Value.Float64Histogram()
+
+			buckets := make([]float, len(h.Buckets))
+			for i, b := range h.Buckets {
+				buckets[i] = float(b)
+			}
+
+			out[s.Name] = histogram{Counts: h.Counts, Buckets: buckets}
+		}
+	}
+
+	writeJSON(w, out)
+}
+
+// serveGoroutines dumps the stacks of all goroutines, in the format of an
+// unrecovered panic
+func serveGoroutines(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+
+	// grow the buffer until the dump fits
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) {
+			w.Write(buf[:n])
+			return
+		}
+
+		buf = make([]byte, 2*len(buf))
+	}
+}
+
+// gcSettings are the settings of GOGC and GOMEMLIMIT
+type gcSettings struct {
+	GCPercent   int64 `json:"gc_percent"`   // -1 when the GC is off
+	MemoryLimit int64 `json:"memory_limit"` // math.MaxInt64 when there is none
+}
+
+// readGCSettings reads the settings through runtime/metrics, the debug
+// setters would have to change them to report them
+func readGCSettings() gcSettings {
+	samples := []metrics.Sample{
+		{Name: "/gc/gogc:percent"},
+		{Name: "/gc/gomemlimit:bytes"},
+	}
+
+	metrics.Read(samples)
+
+	settings := gcSettings{GCPercent: -1, MemoryLimit: math.MaxInt64}
+
+	// gogc reports 0 when the GC is off
+	if p := samples[0].Value.Uint64(); p > 0 {
+		settings.GCPercent = int64(p)
+	}
+
+	if l := samples[1].Value.Uint64(); l <= math.MaxInt64 {
+		settings.MemoryLimit = int64(l)
+	}
+
+	return settings
+}
+
+func serveGC(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, readGCSettings())
+}
+
+// setGC changes the settings sent as gc_percent and memory_limit, like
+// GOGC and GOMEMLIMIT, gc_percent=-1 turns the GC off
+func setGC(w http.ResponseWriter, r *http.Request) {
+	percent, limit := r.FormValue("gc_percent"), r.FormValue("memory_limit")
+
+	if percent == "" && limit == "" {
+		http.Error(w, "gc_percent or memory_limit required", http.StatusBadRequest)
+		return
+	}
+
+	// validate both before changing either
+	var p, l int64
+
+	if percent != "" {
+		v, err := strconv.ParseInt(percent, 10, 32)
+		if err != nil || v < -1 {
+			http.Error(w, fmt.Sprintf("invalid gc_percent %q", percent), http.StatusBadRequest)
+			return
+		}
+
+		p = v
+	}
+
+	if limit != "" {
+		v, err := strconv.ParseInt(limit, 10, 64)
+		if err != nil || v < 0 {
+			http.Error(w, fmt.Sprintf("invalid memory_limit %q", limit), http.StatusBadRequest)
+			return
+		}
+
+		l = v
+	}
+
+	if percent != "" {
+		debug.SetGCPercent(int(p))
+	}
+
+	if limit != "" {
+		debug.SetMemoryLimit(l)
+	}
+
+	writeJSON(w, readGCSettings())
+}
+
+// runGC runs a garbage collection and returns the memory it can to the
+// operating system
+func runGC(w http.ResponseWriter, r *http.Request) {
+	debug.FreeOSMemory()
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func serveBuildInfo(w http.ResponseWriter, r *http.Request) {
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		http.Error(w, "no build info, the binary was not built with module support", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Write([]byte(info.String()))
+}
+
+func (c *config) serveLogLevel(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, map[string]string{"level": c.level.Level().String()})
+}
+
+// setLogLevel sets the level sent as level, by name like debug or warn,
+// with an optional offset like info+2
+func (c *config) setLogLevel(w http.ResponseWriter, r *http.Request) {
+	var level slog.Level
+
+	if err := level.UnmarshalText([]byte(r.FormValue("level"))); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.level.Set(level)
+
+	c.serveLogLevel(w, r)
+}
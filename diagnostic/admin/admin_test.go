@@ -0,0 +1,141 @@
+package admin_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"runtime/debug"
+	"strings"
+	"testing"
+
+	"github.com/tanveerprottoy/advanced-go/diagnostic/admin"
+)
+
+func TestNewRequiresAuth(t *testing.T) {
+	if _, err := admin.New(); !errors.Is(err, admin.ErrNoAuth) {
+		t.Errorf("New() err = %v; want ErrNoAuth", err)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	h, err := admin.New(admin.WithToken("secret"), admin.WithClientCert("ops"))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	verified := func(cn string) *tls.ConnectionState {
+		cert := &x509.Certificate{Subject: pkix.Name{CommonName: cn}}
+		return &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+
+	tests := []struct {
+		name   string
+		header string
+		tls    *tls.ConnectionState
+		code   int
+	}{
+		{"no credentials", "", nil, http.StatusUnauthorized},
+		{"wrong token", "Bearer guess", nil, http.StatusUnauthorized},
+		{"token without scheme", "secret", nil, http.StatusUnauthorized},
+		{"valid token", "Bearer secret", nil, http.StatusOK},
+		{"unverified certificate", "", &tls.ConnectionState{}, http.StatusUnauthorized},
+		{"certificate with another name", "", verified("dev"), http.StatusUnauthorized},
+		{"allowed certificate", "", verified("ops"), http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/debug/gc", nil)
+			req.TLS = tc.tls
+			if tc.header != "" {
+				req.Header.Set("Authorization", tc.header)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.code {
+				t.Errorf("status = %d; want %d", rec.Code, tc.code)
+			}
+		})
+	}
+}
+
+func TestEndpoints(t *testing.T) {
+	level := new(slog.LevelVar)
+
+	h, err := admin.New(admin.WithToken("secret"), admin.WithLogLevel(level))
+	if err != nil {
+		t.Fatalf("New: %v", err)
+	}
+
+	do := func(method, target string, form url.Values) *httptest.ResponseRecorder {
+		t.Helper()
+
+		req := httptest.NewRequest(method, target, strings.NewReader(form.Encode()))
+		req.Header.Set("Authorization", "Bearer secret")
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	t.Run("metrics", func(t *testing.T) {
+		rec := do(http.MethodGet, "/debug/metrics?name=/sched/goroutines:goroutines&name=/gc/pauses:seconds", nil)
+
+		var out map[string]json.RawMessage
+		if err := json.Unmarshal(rec.Body.Bytes(), &out); err != nil {
+			t.Fatalf("decode %s: %v", rec.Body, err)
+		}
+
+		if len(out) != 2 {
+			t.Errorf("metrics = %s; want the two selected ones", rec.Body)
+		}
+	})
+
+	t.Run("goroutines", func(t *testing.T) {
+		rec := do(http.MethodGet, "/debug/goroutines", nil)
+
+		if !strings.Contains(rec.Body.String(), "goroutine ") {
+			t.Errorf("dump = %q; want goroutine stacks", rec.Body)
+		}
+	})
+
+	t.Run("gc", func(t *testing.T) {
+		defer debug.SetGCPercent(debug.SetGCPercent(100))
+
+		rec := do(http.MethodPost, "/debug/gc", url.Values{"gc_percent": {"250"}})
+		if !strings.Contains(rec.Body.String(), `"gc_percent": 250`) {
+			t.Errorf("POST /debug/gc = %d %s; want gc_percent 250", rec.Code, rec.Body)
+		}
+
+		rec = do(http.MethodPost, "/debug/gc", url.Values{"gc_percent": {"lots"}})
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("invalid gc_percent status = %d; want 400", rec.Code)
+		}
+	})
+
+	t.Run("log level", func(t *testing.T) {
+		rec := do(http.MethodPost, "/debug/loglevel", url.Values{"level": {"debug"}})
+
+		if rec.Code != http.StatusOK || level.Level() != slog.LevelDebug {
+			t.Errorf("POST /debug/loglevel = %d, level %v; want DEBUG", rec.Code, level.Level())
+		}
+	})
+
+	t.Run("build info", func(t *testing.T) {
+		rec := do(http.MethodGet, "/debug/buildinfo", nil)
+
+		if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "go\t") {
+			t.Errorf("GET /debug/buildinfo = %d %q", rec.Code, rec.Body)
+		}
+	})
+}
@@ -3,29 +3,42 @@ package remoteapi
 import (
 	"log"
 	"net/http"
-	"net/http/pprof"
+	"os"
 	"sync"
+
+	"github.com/tanveerprottoy/advanced-go/diagnostic/admin"
+	"github.com/tanveerprottoy/advanced-go/pattern/optionex"
 )
 
 func message(w http.ResponseWriter, r *http.Request) {
 	w.Write([]byte("Hello World"))
 }
 
+// Executer serves the public mux on :8080 and the admin handler, with
+// pprof, on localhost:6060 behind a token
 // commands:
-// go tool pprof -alloc_space http://localhost:8080/debug/pprof/heap
-// go tool pprof -inuse_space http://localhost:8080/debug/pprof/heap
+// curl -H "Authorization: Bearer $ADMIN_TOKEN" -o heap.pprof http://localhost:6060/debug/pprof/heap
+// go tool pprof -alloc_space heap.pprof
+// go tool pprof -inuse_space heap.pprof
+// with client certificates instead of the token, pprof fetches directly:
+// go tool pprof -tls_cert client.pem -tls_key client.key -tls_ca ca.pem https://localhost:6060/debug/pprof/heap
 func Executer() {
 	r := http.NewServeMux()
 
 	r.HandleFunc("/", message)
 
-	r.HandleFunc("/debug/pprof/", pprof.Index)
-	r.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
-	r.HandleFunc("/debug/pprof/profile", pprof.Profile)
-	r.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
-	r.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	adminHandler, err := admin.New(admin.WithToken(os.Getenv("ADMIN_TOKEN")))
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	srv := optionex.NewServer(":8080", r, optionex.WithAdminServer("localhost:6060", adminHandler))
 
-	http.ListenAndServe(":8080", r)
+	srv.ConfigureGracefulShutdown(nil)
+
+	if err := srv.Start(); err != nil {
+		log.Println(err)
+	}
 }
 
 // allocMemory allocates 100MBs of memory
@@ -37,6 +50,7 @@ func SingleEndpoint() {
 	var wg sync.WaitGroup
 
 	go func() {
+		// DefaultServeMux has the pprof handlers, it listens on localhost only
 		log.Println(http.ListenAndServe("localhost:8080", nil))
 	}()
 
@@ -0,0 +1,435 @@
+package fileex
+
+import (
+	"errors"
+	"fmt"
+	"html"
+	"io/fs"
+	"log"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// indexFile is served for a directory, and by the SPA fallback
+const indexFile = "index.html"
+
+// DotfilePolicy decides how files and directories whose name starts with a
+// dot, like .git or .env, are served
+type DotfilePolicy int
+
+const (
+	// DotfilesHide answers 404 Not Found, as if they did not exist
+	DotfilesHide DotfilePolicy = iota
+	// DotfilesDeny answers 403 Forbidden
+	DotfilesDeny
+	// DotfilesAllow serves them like any other file
+	DotfilesAllow
+)
+
+type FileServerOption func(*FileServer)
+
+// WithDirectoryListing lists the entries of directories without an
+// index.html, instead of answering 404
+func WithDirectoryListing() FileServerOption {
+	return func(s *FileServer) {
+		s.listing = true
+	}
+}
+
+// WithSPAFallback serves the root index.html for paths that do not exist
+// and have no file extension, so a single page app can route them
+// client side, missing assets like /app.js still get 404
+func WithSPAFallback() FileServerOption {
+	return func(s *FileServer) {
+		s.spa = true
+	}
+}
+
+// WithPrecompressed serves name.gz, when it exists, with
+// Content-Encoding: gzip to clients that accept gzip
+func WithPrecompressed() FileServerOption {
+	return func(s *FileServer) {
+		s.precompressed = true
+	}
+}
+
+// WithDotfiles sets how dotfiles are served, DotfilesHide by default
+// the names in allow are always served, like .well-known
+func WithDotfiles(policy DotfilePolicy, allow ...string) FileServerOption {
+	return func(s *FileServer) {
+		s.dotfiles = policy
+		s.allowedDotfiles = allow
+	}
+}
+
+// FileServer serves the files of a directory, every file is opened
+// through an os.Root, so neither ".." nor a symlink can reach a file
+// outside of the directory, even if the tree changes while it is served
+// it supports conditional and range requests through http.ServeContent,
+// with an ETag derived from the size and modification time
+type FileServer struct {
+	root *os.Root
+
+	listing         bool
+	spa             bool
+	precompressed   bool
+	dotfiles        DotfilePolicy
+	allowedDotfiles []string
+}
+
+// NewFileServer opens dir as the root of the files served, Close releases it
+func NewFileServer(dir string, opts ...FileServerOption) (*FileServer, error) {
+	root, err := os.OpenRoot(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &FileServer{root: root}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	return s, nil
+}
+
+// Close closes the root directory
+func (s *FileServer) Close() error {
+	return s.root.Close()
+}
+
+func (s *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	upath := r.URL.Path
+	if !strings.HasPrefix(upath, "/") {
+		upath = "/" + upath
+	}
+
+	// cleaning a rooted path drops every leading "..", IsLocal rejects
+	// what is left that is not a plain path on this OS, like a volume
+	// name, the root rejects symlinks leading out of it, they are not
+	// found either
+	name := strings.TrimPrefix(path.Clean(upath), "/")
+	if name == "" {
+		name = "."
+	}
+
+	if !filepath.IsLocal(filepath.FromSlash(name)) {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if code := s.checkDotfiles(name); code != 0 {
+		http.Error(w, http.StatusText(code), code)
+		return
+	}
+
+	fi, err := s.root.Stat(name)
+	if err != nil {
+		if s.spa && path.Ext(name) == "" && s.isNotFound(name, err) {
+			s.serveFallback(w, r)
+			return
+		}
+
+		s.serveError(w, name, err)
+		return
+	}
+
+	if fi.IsDir() {
+		// relative links in the directory need the trailing slash
+		if !strings.HasSuffix(upath, "/") {
+			redirect(w, r, path.Base(upath)+"/")
+			return
+		}
+
+		index := path.Join(name, indexFile)
+
+		if ifi, err := s.root.Stat(index); err == nil && ifi.Mode().IsRegular() {
+			s.serveFile(w, r, index)
+			return
+		}
+
+		if s.listing {
+			s.serveDir(w, r, name)
+			return
+		}
+
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	if !fi.Mode().IsRegular() {
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+		return
+	}
+
+	s.serveFile(w, r, name)
+}
+
+// checkDotfiles returns the status for a path with a dotfile in it, or 0
+// if it can be served
+func (s *FileServer) checkDotfiles(name string) int {
+	if s.dotfiles == DotfilesAllow {
+		return 0
+	}
+
+	for _, part := range strings.Split(name, "/") {
+		if !s.hidden(part) {
+			continue
+		}
+
+		if s.dotfiles == DotfilesDeny {
+			return http.StatusForbidden
+		}
+
+		return http.StatusNotFound
+	}
+
+	return 0
+}
+
+// hidden reports whether the policy keeps the entry name from clients
+func (s *FileServer) hidden(name string) bool {
+	return s.dotfiles != DotfilesAllow &&
+		strings.HasPrefix(name, ".") && name != "." &&
+		!slices.Contains(s.allowedDotfiles, name)
+}
+
+// serveFile serves the regular file name, or its precompressed variant
+func (s *FileServer) serveFile(w http.ResponseWriter, r *http.Request, name string) {
+	if s.precompressed {
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if acceptsGzip(r) && s.serveGzip(w, r, name) {
+			return
+		}
+	}
+
+	f, err := s.root.Open(name)
+	if err != nil {
+		s.serveError(w, name, err)
+		return
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		s.serveError(w, name, err)
+		return
+	}
+
+	w.Header().Set("ETag", etag(fi, ""))
+
+	// ServeContent handles Range, If-Range, If-None-Match and
+	// If-Modified-Since, and sniffs the type of unknown extensions
+	http.ServeContent(w, r, fi.Name(), fi.ModTime(), f)
+}
+
+// serveGzip serves name.gz if it exists, it reports false if it does not
+func (s *FileServer) serveGzip(w http.ResponseWriter, r *http.Request, name string) bool {
+	f, err := s.root.Open(name + ".gz")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+
+	// the type is the one of the original, sniffing would see gzip
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", ctype)
+	h.Set("Content-Encoding", "gzip")
+	// the encoded bytes differ, so must the ETag
+	h.Set("ETag", etag(fi, "-gz"))
+
+	http.ServeContent(w, r, name, fi.ModTime(), f)
+
+	return true
+}
+
+// serveFallback serves the root index.html of a single page app, it must
+// not be cached so a new deploy is picked up
+func (s *FileServer) serveFallback(w http.ResponseWriter, r *http.Request) {
+	if _, err := s.root.Stat(indexFile); err != nil {
+		s.serveError(w, indexFile, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-cache")
+
+	s.serveFile(w, r, indexFile)
+}
+
+// serveDir lists the entries of the directory name
+func (s *FileServer) serveDir(w http.ResponseWriter, r *http.Request, name string) {
+	f, err := s.root.Open(name)
+	if err != nil {
+		s.serveError(w, name, err)
+		return
+	}
+	defer f.Close()
+
+	entries, err := f.ReadDir(-1)
+	if err != nil {
+		s.serveError(w, name, err)
+		return
+	}
+
+	slices.SortFunc(entries, func(a, b fs.DirEntry) int {
+		return strings.Compare(a.Name(), b.Name())
+	})
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+	fmt.Fprintf(w, "<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n")
+
+	for _, e := range entries {
+		n := e.Name()
+		if s.hidden(n) {
+			continue
+		}
+
+		if e.IsDir() {
+			n += "/"
+		}
+
+		// the name is a path segment in the link and text in the page
+		u := url.URL{Path: n}
+		fmt.Fprintf(w, "<a href=\"%s\">%s</a>\n", u.EscapedPath(), html.EscapeString(n))
+	}
+
+	fmt.Fprintf(w, "</pre>\n")
+}
+
+// serveError maps an error of the root on name to a response, without
+// revealing anything about files outside of it
+func (s *FileServer) serveError(w http.ResponseWriter, name string, err error) {
+	switch {
+	case s.isNotFound(name, err):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
+
+// isNotFound reports whether err means there is no file name in the
+// root, a symlink leading out of the root is not found either, so a
+// client cannot tell it from a missing file, it is logged for the
+// operator as it points at a broken deploy
+func (s *FileServer) isNotFound(name string, err error) bool {
+	if errors.Is(err, fs.ErrNotExist) {
+		return true
+	}
+
+	if errors.Is(err, fs.ErrPermission) || !s.escapes(name) {
+		return false
+	}
+
+	log.Printf("fileex: %s links out of the root %s", name, s.root.Name())
+
+	return true
+}
+
+// escapes reports whether name leads out of the root through a symlink,
+// it follows the links of the path itself, os.Root has no error to tell
+// an escape by, the targets need not exist
+func (s *FileServer) escapes(name string) bool {
+	dir, err := filepath.EvalSymlinks(s.root.Name())
+	if err != nil {
+		return false
+	}
+
+	rest := strings.Split(name, "/")
+	cur := dir
+
+	for links := 0; len(rest) > 0; {
+		next := filepath.Join(cur, rest[0])
+		rest = rest[1:]
+
+		target, err := os.Readlink(next)
+		if err != nil {
+			// not a link, or missing
+			cur = next
+			continue
+		}
+
+		// a loop is left to the root to report
+		if links++; links > 255 {
+			return false
+		}
+
+		if !filepath.IsAbs(target) {
+			target = filepath.Join(cur, target)
+		}
+
+		rel, err := filepath.Rel(dir, target)
+		if err != nil || !filepath.IsLocal(rel) {
+			return true
+		}
+
+		// resolve what the link points at, from the top
+		rest = append(strings.Split(filepath.ToSlash(rel), "/"), rest...)
+		cur = dir
+	}
+
+	return false
+}
+
+// etag is a strong validator from the size and modification time, like
+// the ones nginx sends
+func etag(fi fs.FileInfo, suffix string) string {
+	return `"` + strconv.FormatInt(fi.ModTime().UnixNano(), 16) + "-" + strconv.FormatInt(fi.Size(), 16) + suffix + `"`
+}
+
+// acceptsGzip reports whether the Accept-Encoding header allows gzip
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+
+		if !strings.EqualFold(strings.TrimSpace(coding), "gzip") {
+			continue
+		}
+
+		q, ok := strings.CutPrefix(strings.ReplaceAll(params, " ", ""), "q=")
+		if !ok {
+			return true
+		}
+
+		v, err := strconv.ParseFloat(q, 64)
+		return err == nil && v > 0
+	}
+
+	return false
+}
+
+// redirect answers with a relative redirect, keeping the query
+func redirect(w http.ResponseWriter, r *http.Request, target string) {
+	if q := r.URL.RawQuery; q != "" {
+		target += "?" + q
+	}
+
+	w.Header().Set("Location", target)
+	w.WriteHeader(http.StatusMovedPermanently)
+}
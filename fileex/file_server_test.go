@@ -0,0 +1,185 @@
+package fileex
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+
+	for name, content := range files {
+		p := filepath.Join(dir, filepath.FromSlash(name))
+
+		if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+			t.Fatalf("MkdirAll: %v", err)
+		}
+
+		if err := os.WriteFile(p, []byte(content), 0o644); err != nil {
+			t.Fatalf("WriteFile: %v", err)
+		}
+	}
+}
+
+func gzipped(t *testing.T, s string) string {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	zw := gzip.NewWriter(&buf)
+	zw.Write([]byte(s))
+	zw.Close()
+
+	return buf.String()
+}
+
+func TestFileServer(t *testing.T) {
+	base := t.TempDir()
+	dir := filepath.Join(base, "public")
+
+	writeFiles(t, base, map[string]string{"secret.txt": "outside"})
+	writeFiles(t, dir, map[string]string{
+		"index.html":                "home",
+		"app.js":                    "console.log('app')",
+		"app.js.gz":                 gzipped(t, "console.log('app')"),
+		"docs/a.txt":                "0123456789",
+		"docs/.env":                 "KEY=1",
+		".git/config":               "[core]",
+		".well-known/security.txt":  "Contact: ops",
+		"assets/empty/.placeholder": "",
+	})
+
+	for link, target := range map[string]string{
+		"escape.txt":   filepath.Join(base, "secret.txt"),
+		"dangling.txt": filepath.Join(base, "missing.txt"),
+		"up":           "..",
+	} {
+		if err := os.Symlink(target, filepath.Join(dir, link)); err != nil {
+			t.Fatalf("Symlink: %v", err)
+		}
+	}
+
+	srv, err := NewFileServer(dir,
+		WithDirectoryListing(),
+		WithSPAFallback(),
+		WithPrecompressed(),
+		WithDotfiles(DotfilesHide, ".well-known"),
+	)
+	if err != nil {
+		t.Fatalf("NewFileServer: %v", err)
+	}
+	defer srv.Close()
+
+	tests := []struct {
+		name    string
+		method  string
+		target  string
+		headers map[string]string
+		code    int
+		body    string
+		header  map[string]string
+	}{
+		{name: "index", target: "/", code: http.StatusOK, body: "home"},
+		{name: "file", target: "/docs/a.txt", code: http.StatusOK, body: "0123456789"},
+		{name: "dot dot", target: "/../secret.txt", code: http.StatusNotFound},
+		{name: "encoded dot dot", target: "/docs/%2e%2e/%2e%2e/secret.txt", code: http.StatusNotFound},
+		{name: "symlink escape", target: "/escape.txt", code: http.StatusNotFound},
+		{name: "dangling symlink escape", target: "/dangling.txt", code: http.StatusNotFound},
+		{name: "directory symlink escape", target: "/up/secret.txt", code: http.StatusNotFound},
+		{name: "hidden dotfile", target: "/docs/.env", code: http.StatusNotFound},
+		{name: "hidden dot directory", target: "/.git/config", code: http.StatusNotFound},
+		{name: "allowed dotfile", target: "/.well-known/security.txt", code: http.StatusOK, body: "Contact: ops"},
+		{
+			name: "range", target: "/docs/a.txt",
+			headers: map[string]string{"Range": "bytes=2-4"},
+			code:    http.StatusPartialContent, body: "234",
+		},
+		{
+			name: "precompressed", target: "/app.js",
+			headers: map[string]string{"Accept-Encoding": "br, gzip"},
+			code:    http.StatusOK, body: gzipped(t, "console.log('app')"),
+			header: map[string]string{"Content-Encoding": "gzip", "Content-Type": "text/javascript; charset=utf-8", "Vary": "Accept-Encoding"},
+		},
+		{
+			name: "gzip refused", target: "/app.js",
+			headers: map[string]string{"Accept-Encoding": "gzip;q=0"},
+			code:    http.StatusOK, body: "console.log('app')",
+			header: map[string]string{"Content-Encoding": ""},
+		},
+		{name: "directory redirect", target: "/docs?x=1", code: http.StatusMovedPermanently, header: map[string]string{"Location": "docs/?x=1"}},
+		{name: "listing hides dotfiles", target: "/docs/", code: http.StatusOK, body: "<!doctype html>\n<meta name=\"viewport\" content=\"width=device-width\">\n<pre>\n<a href=\"a.txt\">a.txt</a>\n</pre>\n"},
+		{name: "spa fallback", target: "/settings/profile", code: http.StatusOK, body: "home", header: map[string]string{"Cache-Control": "no-cache"}},
+		{name: "missing asset", target: "/missing.css", code: http.StatusNotFound},
+		{name: "method", method: http.MethodPost, target: "/", code: http.StatusMethodNotAllowed},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			method := tc.method
+			if method == "" {
+				method = http.MethodGet
+			}
+
+			req := httptest.NewRequest(method, tc.target, nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			rec := httptest.NewRecorder()
+			srv.ServeHTTP(rec, req)
+
+			if rec.Code != tc.code {
+				t.Fatalf("status = %d; want %d, body %q", rec.Code, tc.code, rec.Body)
+			}
+
+			if tc.body != "" && rec.Body.String() != tc.body {
+				t.Errorf("body = %q; want %q", rec.Body, tc.body)
+			}
+
+			for k, v := range tc.header {
+				if got := rec.Header().Get(k); got != v {
+					t.Errorf("%s = %q; want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestFileServerConditional(t *testing.T) {
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"a.txt": "hello"})
+
+	srv, err := NewFileServer(dir)
+	if err != nil {
+		t.Fatalf("NewFileServer: %v", err)
+	}
+	defer srv.Close()
+
+	rec := httptest.NewRecorder()
+	srv.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/a.txt", nil))
+
+	etag, modified := rec.Header().Get("ETag"), rec.Header().Get("Last-Modified")
+	if !strings.HasPrefix(etag, `"`) || modified == "" {
+		t.Fatalf("ETag = %q, Last-Modified = %q; want both", etag, modified)
+	}
+
+	for _, h := range []map[string]string{{"If-None-Match": etag}, {"If-Modified-Since": modified}} {
+		req := httptest.NewRequest(http.MethodGet, "/a.txt", nil)
+		for k, v := range h {
+			req.Header.Set(k, v)
+		}
+
+		rec := httptest.NewRecorder()
+		srv.ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("%v: status = %d; want 304", h, rec.Code)
+		}
+	}
+}
@@ -1,6 +1,6 @@
 module github.com/tanveerprottoy/advanced-go
 
-go 1.24
+go 1.25
 
 require (
 	github.com/mmcdole/gofeed v1.3.0
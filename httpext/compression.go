@@ -0,0 +1,171 @@
+package httpext
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// supported content encodings
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+// DefaultMaxDecompressedSize is the default cap for a decompressed
+// response body, it guards against decompression bombs
+const DefaultMaxDecompressedSize int64 = 10 << 20 // 10MB
+
+var (
+	ErrUnsupportedEncoding = errors.New("httpext: unsupported content encoding")
+	ErrBodyTooLarge        = errors.New("httpext: body exceeds the maximum size")
+)
+
+// compressBody compresses b with the encoding
+func compressBody(encoding string, b []byte) ([]byte, error) {
+	var (
+		buf bytes.Buffer
+		w   io.WriteCloser
+	)
+
+	switch encoding {
+	case EncodingGzip:
+		w = gzip.NewWriter(&buf)
+	case EncodingDeflate:
+		// deflate in HTTP means the zlib format (RFC 1950), not raw deflate
+		w = zlib.NewWriter(&buf)
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrUnsupportedEncoding, encoding)
+	}
+
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+
+	// Close flushes the remaining data and writes the footer
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// decompressResponse replaces resp.Body with a decoding reader when the
+// response carries a gzip or deflate Content-Encoding
+// Go's transport decompresses gzip transparently only when it added the
+// Accept-Encoding header itself, once the caller sets it manually the
+// body arrives encoded, this handles that case
+// the decoded body is capped at maxSize bytes, reading past it fails
+// with ErrBodyTooLarge
+func decompressResponse(resp *http.Response, maxSize int64) error {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return nil
+	}
+
+	var (
+		r   io.ReadCloser
+		err error
+	)
+
+	switch encoding {
+	case EncodingGzip, "x-gzip":
+		r, err = gzip.NewReader(resp.Body)
+	case EncodingDeflate:
+		r, err = newDeflateReader(resp.Body)
+	default:
+		return fmt.Errorf("%w: %q", ErrUnsupportedEncoding, encoding)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if maxSize <= 0 {
+		maxSize = DefaultMaxDecompressedSize
+	}
+
+	resp.Body = &decompressedBody{
+		r:    &limitedReader{r: r, n: maxSize},
+		dec:  r,
+		body: resp.Body,
+	}
+
+	// the body no longer matches the headers, the same as the transport
+	// does when it decompresses on its own
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+
+	return nil
+}
+
+// newDeflateReader reads a deflate body, some servers send raw deflate
+// instead of the zlib format so the header is checked first
+func newDeflateReader(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	h, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	// a zlib header has CM = 8 in the low nibble and is a multiple of 31
+	if h[0]&0x0f == 8 && (uint16(h[0])<<8|uint16(h[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+
+	return flate.NewReader(br), nil
+}
+
+// decompressedBody closes both the decoder and the underlying body
+type decompressedBody struct {
+	r    io.Reader
+	dec  io.Closer
+	body io.Closer
+}
+
+func (d *decompressedBody) Read(p []byte) (int, error) {
+	return d.r.Read(p)
+}
+
+func (d *decompressedBody) Close() error {
+	d.dec.Close()
+
+	return d.body.Close()
+}
+
+// limitedReader is like io.LimitedReader, but it reports ErrBodyTooLarge
+// instead of a silent io.EOF once the limit is exceeded
+type limitedReader struct {
+	r io.Reader
+	n int64 // bytes remaining
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, ErrBodyTooLarge
+	}
+
+	// read one byte past the limit to tell an exact fit from an overflow
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+
+	if l.n < 0 {
+		return n + int(l.n), ErrBodyTooLarge
+	}
+
+	return n, err
+}
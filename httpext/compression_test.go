@@ -0,0 +1,140 @@
+package httpext_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanveerprottoy/advanced-go/httpext"
+	"github.com/tanveerprottoy/advanced-go/httpext/httpfake"
+)
+
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	w := gzip.NewWriter(&buf)
+	w.Write(b)
+	if err := w.Close(); err != nil {
+		t.Fatalf("gzip: %v", err)
+	}
+
+	return buf.Bytes()
+}
+
+// gunzipJSON matches a gzip compressed body that decodes to the json document s
+func gunzipJSON(s string) httpfake.BodyMatcher {
+	match := httpfake.BodyJSON(s)
+
+	return func(body []byte) bool {
+		r, err := gzip.NewReader(bytes.NewReader(body))
+		if err != nil {
+			return false
+		}
+
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return false
+		}
+
+		return match(b)
+	}
+}
+
+func TestRequestCompression(t *testing.T) {
+	name := strings.Repeat("apple", 100)
+
+	srv := httpfake.NewServer(t,
+		httpfake.Expect(http.MethodPost, "/api/v1/products").
+			Header("Content-Encoding", "gzip").
+			Body(gunzipJSON(`{"id":0,"name":"`+name+`"}`)).
+			Respond(httpfake.JSON(http.StatusCreated, product{ID: 1, Name: name})).
+			Times(1),
+		httpfake.Expect(http.MethodPost, "/api/v1/products").
+			Header("Content-Encoding", "").
+			Body(httpfake.BodyJSON(`{"id":0,"name":"pear"}`)).
+			Respond(httpfake.JSON(http.StatusCreated, product{ID: 2, Name: "pear"})).
+			Times(1),
+	)
+
+	client := httpext.NewCustomClient(httpext.Config{Timeout: 5 * time.Second})
+	svc := httpext.NewService[product, errorResponse](
+		client,
+		httpext.WithRequestCompression(httpext.EncodingGzip, 64),
+	)
+
+	for _, n := range []string{name, "pear"} {
+		b, _ := json.Marshal(product{Name: n})
+
+		res, _, err := svc.Request(context.Background(), http.MethodPost, srv.URL()+"/api/v1/products", nil, bytes.NewReader(b), false)
+		if err != nil {
+			t.Fatalf("Request() err = %v", err)
+		}
+
+		if res.Name != n {
+			t.Errorf("Request() name = %q; want %q", res.Name, n)
+		}
+	}
+}
+
+func TestResponseDecompression(t *testing.T) {
+	body, _ := json.Marshal(product{ID: 1, Name: "apple"})
+
+	var deflated bytes.Buffer
+	w := zlib.NewWriter(&deflated)
+	w.Write(body)
+	w.Close()
+
+	srv := httpfake.NewServer(t,
+		httpfake.Expect(http.MethodGet, "/gzip").
+			Respond(httpfake.Response{
+				Status: http.StatusOK,
+				Header: http.Header{"Content-Encoding": {"gzip"}},
+				Body:   gzipBytes(t, body),
+			}),
+		httpfake.Expect(http.MethodGet, "/deflate").
+			Respond(httpfake.Response{
+				Status: http.StatusOK,
+				Header: http.Header{"Content-Encoding": {"deflate"}},
+				Body:   deflated.Bytes(),
+			}),
+		httpfake.Expect(http.MethodGet, "/bomb").
+			Respond(httpfake.Response{
+				Status: http.StatusOK,
+				Header: http.Header{"Content-Encoding": {"gzip"}},
+				Body:   gzipBytes(t, append([]byte(`"`), bytes.Repeat([]byte("a"), 1<<20)...)),
+			}),
+	)
+
+	client := httpext.NewCustomClient(httpext.Config{Timeout: 5 * time.Second})
+	svc := httpext.NewService[product, errorResponse](client, httpext.WithMaxDecompressedSize(1024))
+
+	// setting Accept-Encoding manually turns off the transport's own
+	// decompression, so the service has to do it
+	header := http.Header{"Accept-Encoding": {"gzip, deflate"}}
+
+	for _, path := range []string{"/gzip", "/deflate"} {
+		res, _, err := svc.Request(context.Background(), http.MethodGet, srv.URL()+path, header, nil, false)
+		if err != nil {
+			t.Fatalf("Request(%s) err = %v", path, err)
+		}
+
+		if res.ID != 1 || res.Name != "apple" {
+			t.Errorf("Request(%s) res = %v", path, res)
+		}
+	}
+
+	_, _, err := svc.Request(context.Background(), http.MethodGet, srv.URL()+"/bomb", header, nil, false)
+	if !errors.Is(err, httpext.ErrBodyTooLarge) {
+		t.Errorf("Request(/bomb) err = %v; want ErrBodyTooLarge", err)
+	}
+}
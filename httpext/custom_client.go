@@ -2,18 +2,31 @@ package httpext
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 	"math"
 	"math/rand"
 	"net/http"
+	"strconv"
 	"time"
 )
 
+// ErrRetryBudgetExceeded is returned when the deadline of the request
+// context is too close to wait for the next backoff and retry
+var ErrRetryBudgetExceeded = errors.New("httpext: remaining deadline is shorter than the next backoff")
+
+// errAttemptTimeout marks an attempt that ran out of the per attempt timeout
+// while the request itself still had time left
+var errAttemptTimeout = errors.New("httpext: attempt timed out")
+
 type Config struct {
-	MaxRetries int           // maximum number of retries for a request
-	MaxJitter  int           // maximum jitter in milliseconds
-	Timeout    time.Duration // request timeout
+	MaxRetries     int           // maximum number of attempts for a request
+	MaxJitter      int           // maximum jitter in milliseconds
+	Timeout        time.Duration // timeout of the underlying http.Client, applies to every Do call
+	AttemptTimeout time.Duration // timeout of a single attempt when retrying, 0 means no limit
 }
 
 type Option func(*customClient)
@@ -36,12 +49,25 @@ func WithIdleConnTimeout(idleConnTimeout time.Duration) Option {
 	}
 }
 
+// WithDeadlineHeader sends the time left until the attempt deadline, in
+// milliseconds, in the header name, so the server can give up on work
+// the client will not wait for
+func WithDeadlineHeader(name string) Option {
+	return func(c *customClient) {
+		c.deadlineHeader = name
+	}
+}
+
 // customClient is a custom HTTP client that implements the Client interface
 type customClient struct {
 	httpClient *http.Client
 	maxRetries int
 	maxJitter  int
 
+	// retry options
+	attemptTimeout time.Duration
+	deadlineHeader string
+
 	// transport options
 	maxIdleConnsPerHost int
 	idleConnTimeout     time.Duration
@@ -64,9 +90,10 @@ func NewCustomClient(cfg Config, opts ...Option) *customClient {
 	httpClient := &http.Client{Timeout: cfg.Timeout}
 
 	c := &customClient{
-		httpClient: httpClient,
-		maxRetries: cfg.MaxRetries,
-		maxJitter:  cfg.MaxJitter,
+		httpClient:     httpClient,
+		maxRetries:     cfg.MaxRetries,
+		maxJitter:      cfg.MaxJitter,
+		attemptTimeout: cfg.AttemptTimeout,
 	}
 
 	// apply options
@@ -101,8 +128,14 @@ func (c *customClient) jitter(max, attempts int) time.Duration {
 	return time.Duration(int(attempts*rnd)) * time.Millisecond
 }
 
-func (c *customClient) restoreRequestBody(req *http.Request) error {
-	if req.Body == nil {
+// bufferRequestBody reads the request body once and sets req.GetBody so
+// every attempt can send it again
+// reusing a request body can be a bit tricky because the io.ReadCloser
+// interface, which is the type of r.Body in an http.Request, is designed
+// for single consumption. Once you've read the body, the underlying reader
+// is at its end, and attempting to read it again will yield an empty result
+func (c *customClient) bufferRequestBody(req *http.Request) error {
+	if req.Body == nil || req.Body == http.NoBody || req.GetBody != nil {
 		return nil
 	}
 
@@ -112,9 +145,13 @@ func (c *customClient) restoreRequestBody(req *http.Request) error {
 		return err
 	}
 
-	// Restore the body so it can be read again
-	// This is important because the body is an io.ReadCloser and can only be read once
-	req.Body = io.NopCloser(bytes.NewBuffer(buf))
+	req.Body.Close()
+
+	req.GetBody = func() (io.ReadCloser, error) {
+		return io.NopCloser(bytes.NewReader(buf)), nil
+	}
+
+	req.Body, _ = req.GetBody()
 
 	return nil
 }
@@ -124,6 +161,11 @@ func (c *customClient) isRetryableError(err error) bool {
 		return false
 	}
 
+	// the attempt ran out of its own time, the next one may succeed
+	if errors.Is(err, errAttemptTimeout) {
+		return true
+	}
+
 	// check if error is temporary
 	if errNet, ok := err.(interface{ Temporary() bool }); ok && errNet.Temporary() {
 		return true
@@ -132,73 +174,114 @@ func (c *customClient) isRetryableError(err error) bool {
 	return false
 }
 
-func (c *customClient) drainBody(resp *http.Response) {
-	// drain the response body to reuse the connection
-	// only do this if the response is not nil and the body is not nil
-	if resp != nil && resp.Body != nil {
-		io.Copy(io.Discard, resp.Body)
-		resp.Body.Close()
+// sleep waits for d, it returns early with the context error if ctx is
+// done before that, unlike time.Sleep
+func (c *customClient) sleep(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
 	}
 }
 
-func (c *customClient) doWithRetry(req *http.Request) (*http.Response, error) {
-	var (
-		attempts int
-		resp     *http.Response
-		err      error
-	)
-
-	for attempts < c.maxRetries {
-		log.Printf("customClient: doWithRetry called, attempt %d\n", attempts)
-
-		// reusing a request body can be a bit tricky because the
-		// io.ReadCloser interface, which is the type of r.Body in an
-		// http.Request, is designed for single consumption. Once you've read the body, the underlying reader is often at its end, and attempting to read it again will yield an empty result or an error.
-		// Always rewind/restore the request body when non-nil.
-		if req.Body != nil {
-			if err := c.restoreRequestBody(req); err != nil {
-				return nil, err
-			}
+// doAttempt sends one attempt of req, bounded by the attempt timeout and
+// by the deadline of the request context, whichever comes first
+func (c *customClient) doAttempt(req *http.Request) (*http.Response, error) {
+	ctx, cancel := req.Context(), func() {}
+	if c.attemptTimeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, c.attemptTimeout)
+	}
+
+	// each attempt gets its own copy of the request so the context,
+	// the deadline header and the body are fresh
+	attempt := req.Clone(ctx)
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			cancel()
+			return nil, err
 		}
 
-		resp, err = c.httpClient.Do(req)
+		attempt.Body = body
+	}
 
-		log.Printf("customClient: doWithRetry.httpClient.Do called, attempt %d, response: %v\nerr: %v\n", attempts, resp, err)
+	c.setDeadlineHeader(attempt)
 
-		if err != nil {
-			log.Printf("customClient: doWithRetry.httpClient.Do if err != nil: %v\n", err)
+	resp, err := c.httpClient.Do(attempt)
+	if err != nil {
+		cancel()
 
-			// check if error is temporary
-			if c.isRetryableError(err) {
-				// temp
-				log.Printf("backoff: %v\njitter: %v\n", c.backoff(attempts), c.jitter(c.maxJitter, attempts))
+		// tell an expired attempt apart from an expired request
+		if errors.Is(err, context.DeadlineExceeded) && req.Context().Err() == nil {
+			return nil, fmt.Errorf("%w: %w", errAttemptTimeout, err)
+		}
 
-				// drain the response body to reuse the connection
-				c.drainBody(resp)
+		return nil, err
+	}
 
-				// wait for backoff time
-				time.Sleep(c.backoff(attempts) + c.jitter(c.maxJitter, attempts))
+	// the attempt context must live until the body has been read
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
 
-				// continue to next attempt
-				continue
-			}
+	return resp, nil
+}
+
+// setDeadlineHeader sets the deadline header of req, if the option is on
+// and its context has a deadline
+func (c *customClient) setDeadlineHeader(req *http.Request) {
+	if deadline, ok := req.Context().Deadline(); ok && c.deadlineHeader != "" {
+		req.Header.Set(c.deadlineHeader, strconv.FormatInt(time.Until(deadline).Milliseconds(), 10))
+	}
+}
 
+func (c *customClient) doWithRetry(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+
+	if err := c.bufferRequestBody(req); err != nil {
+		return nil, err
+	}
+
+	for attempts := 0; ; attempts++ {
+		resp, err := c.doAttempt(req)
+		if err == nil {
+			return resp, nil
+		}
+
+		if !c.isRetryableError(err) {
 			return nil, err
 		}
 
-		// increment attempts
-		attempts++
+		if attempts+1 >= c.maxRetries {
+			return nil, fmt.Errorf("request failed after %d attempts: %w", attempts+1, err)
+		}
 
-		log.Printf("customClient: doWithRetry after increment attempts: %d\n", attempts)
-	}
+		wait := c.backoff(attempts) + c.jitter(c.maxJitter, attempts)
 
-	return resp, nil
+		// do not start a retry which cannot finish in time
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < wait {
+			return nil, fmt.Errorf("%w: %w", ErrRetryBudgetExceeded, err)
+		}
+
+		// wait for backoff time, or until the request is cancelled
+		if err := c.sleep(ctx, wait); err != nil {
+			return nil, err
+		}
+	}
 }
 
 func (c *customClient) doWithoutRetry(req *http.Request) (*http.Response, error) {
 	// do without retry
 	log.Println("customClient: doWithoutRetry called")
 
+	if c.deadlineHeader != "" {
+		// the caller's request is not modified
+		req = req.Clone(req.Context())
+		c.setDeadlineHeader(req)
+	}
+
 	return c.httpClient.Do(req)
 }
 
@@ -216,3 +299,16 @@ func (c *customClient) Do(req *http.Request, retry bool) (*http.Response, error)
 func (c *customClient) HTTPClient() *http.Client {
 	return c.httpClient
 }
+
+// cancelOnClose releases the attempt context once the body is closed
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelOnClose) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+
+	return err
+}
@@ -2,11 +2,17 @@ package httpext_test
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/tanveerprottoy/advanced-go/httpext"
+	"github.com/tanveerprottoy/advanced-go/httpext/httpfake"
 )
 
 func TestCustomClient(t *testing.T) {
@@ -22,14 +28,20 @@ func TestCustomClient(t *testing.T) {
 		httpext.WithMaxIdleConnsPerHost(20),
 	)
 
+	// one call for each subtest, a successful attempt is never repeated
+	srv := httpfake.NewServer(t,
+		httpfake.Expect(http.MethodGet, "/api/v1/products").
+			Respond(httpfake.JSON(http.StatusOK, []string{"apple", "orange"})).
+			Times(2),
+	)
+
 	// subtest testDo
 	t.Run("testDo", func(t *testing.T) {
 		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 
 		defer cancel()
 
-		// Mock a request
-		req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://localhost:8080/api/v1/products", nil)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL()+"/api/v1/products", nil)
 		if err != nil {
 			t.Errorf("failed to create request: %v", err)
 			return
@@ -46,10 +58,166 @@ func TestCustomClient(t *testing.T) {
 			return
 		}
 
+		defer resp.Body.Close()
+
 		if resp.StatusCode != http.StatusOK {
 			t.Errorf("expected status code 200, got %d", resp.StatusCode)
 			return
 		}
 	})
 
+	// subtest testDoWithoutRetry
+	t.Run("testDoWithoutRetry", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL()+"/api/v1/products", nil)
+		if err != nil {
+			t.Errorf("failed to create request: %v", err)
+			return
+		}
+
+		resp, err := client.Do(req, false)
+		if err != nil {
+			t.Errorf("client.Do error: %v", err)
+			return
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("expected status code 200, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestCustomClientAttemptTimeout(t *testing.T) {
+	cfg := httpext.Config{
+		MaxRetries:     3,
+		Timeout:        10 * time.Second,
+		AttemptTimeout: 100 * time.Millisecond,
+	}
+
+	client := httpext.NewCustomClient(cfg, httpext.WithDeadlineHeader("X-Request-Timeout"))
+
+	t.Run("retries a timed out attempt", func(t *testing.T) {
+		srv := httpfake.NewServer(t,
+			httpfake.Expect(http.MethodPost, "/orders").
+				Body(httpfake.BodyEquals("order")).
+				Respond(
+					httpfake.Status(http.StatusOK).WithDelay(time.Second),
+					httpfake.Status(http.StatusCreated),
+				).
+				Times(2),
+		)
+
+		// the body is not rewindable on its own, it has to be sent again
+		body := io.NopCloser(strings.NewReader("order"))
+
+		req, _ := http.NewRequest(http.MethodPost, srv.URL()+"/orders", body)
+
+		resp, err := client.Do(req, true)
+		if err != nil {
+			t.Fatalf("client.Do error: %v", err)
+		}
+
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusCreated {
+			t.Errorf("expected status code 201, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("skips retry when the budget is too short", func(t *testing.T) {
+		srv := httpfake.NewServer(t,
+			httpfake.Expect(http.MethodGet, "/orders").
+				Respond(httpfake.Status(http.StatusOK).WithDelay(time.Second)).
+				Times(1),
+		)
+
+		// the first backoff is a second, longer than what is left
+		ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+		defer cancel()
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL()+"/orders", nil)
+
+		start := time.Now()
+
+		_, err := client.Do(req, true)
+		if !errors.Is(err, httpext.ErrRetryBudgetExceeded) {
+			t.Errorf("client.Do err = %v; want ErrRetryBudgetExceeded", err)
+		}
+
+		if d := time.Since(start); d > 400*time.Millisecond {
+			t.Errorf("client.Do took %v; want it to give up right after the first attempt", d)
+		}
+	})
+
+	t.Run("backoff ends when the context is cancelled", func(t *testing.T) {
+		srv := httpfake.NewServer(t,
+			httpfake.Expect(http.MethodGet, "/orders").
+				Respond(httpfake.Status(http.StatusOK).WithDelay(time.Second)).
+				Times(1),
+		)
+
+		ctx, cancel := context.WithCancel(context.Background())
+		time.AfterFunc(200*time.Millisecond, cancel)
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL()+"/orders", nil)
+
+		_, err := client.Do(req, true)
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("client.Do err = %v; want context.Canceled", err)
+		}
+	})
+
+	t.Run("sends the remaining deadline", func(t *testing.T) {
+		var got string
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Get("X-Request-Timeout")
+		}))
+		defer srv.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, srv.URL, nil)
+
+		resp, err := client.Do(req, true)
+		if err != nil {
+			t.Fatalf("client.Do error: %v", err)
+		}
+
+		resp.Body.Close()
+
+		ms, err := strconv.Atoi(got)
+		if err != nil || ms <= 0 || ms > 100 {
+			t.Errorf("X-Request-Timeout = %q; want milliseconds in (0, 100]", got)
+		}
+	})
+
+	t.Run("sends the deadline without retry", func(t *testing.T) {
+		var got string
+
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			got = r.Header.Get("X-Request-Timeout")
+		}))
+		defer srv.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+
+		resp, err := client.Do(req, false)
+		if err != nil {
+			t.Fatalf("client.Do error: %v", err)
+		}
+
+		resp.Body.Close()
+
+		ms, err := strconv.Atoi(got)
+		if err != nil || ms <= 0 || ms > 60000 {
+			t.Errorf("X-Request-Timeout = %q; want milliseconds in (0, 60000]", got)
+		}
+
+		if req.Header.Get("X-Request-Timeout") != "" {
+			t.Error("the header was set on the caller's request")
+		}
+	})
 }
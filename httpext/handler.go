@@ -0,0 +1,270 @@
+package httpext
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/tanveerprottoy/advanced-go/validator"
+)
+
+// DefaultMaxBodySize caps the request body Handle decodes
+const DefaultMaxBodySize = 1 << 20
+
+type HandlerOption func(*handlerConfig)
+
+type handlerConfig struct {
+	maxBodySize int64
+	status      int
+}
+
+// WithMaxBodySize caps the request body, larger ones get 413
+func WithMaxBodySize(n int64) HandlerOption {
+	return func(c *handlerConfig) {
+		c.maxBodySize = n
+	}
+}
+
+// WithStatus sets the status of successful responses, 200 by default,
+// with 204 No Content the response is not encoded
+func WithStatus(code int) HandlerOption {
+	return func(c *handlerConfig) {
+		c.status = code
+	}
+}
+
+// Handle adapts fn to an http.Handler, it binds the request to Req,
+// validates it, calls fn and encodes the Resp it returns as JSON
+//
+// binding decodes a JSON body strictly, unknown fields, trailing data and
+// bodies above the size limit are rejected, then the struct fields of Req
+// tagged `path:"name"` are set from the ServeMux path values, like {id} in
+// "GET /products/{id}", and the ones tagged `query:"name"` from the query
+// if Req, or *Req, implements validator.Validator, Validate runs next, an
+// error fails the request with 422 and lists the errors it joins
+//
+// errors are sent as RFC 9457 application/problem+json, a *Problem is
+// sent as is, an error with a StatusCode() int method gets that status,
+// and its PublicDetail() string as detail if it has one, ErrNotFound and
+// the other sentinels their status, with the text wrapped before them as
+// detail, anything else gets 500 without detail, so internals do not
+// leak to the client
+func Handle[Req, Resp any](fn func(ctx context.Context, req Req) (Resp, error), opts ...HandlerOption) http.Handler {
+	cfg := &handlerConfig{
+		maxBodySize: DefaultMaxBodySize,
+		status:      http.StatusOK,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req Req
+
+		if p := bind(w, r, &req, cfg.maxBodySize); p != nil {
+			WriteProblem(w, p)
+			return
+		}
+
+		if err := validate(&req); err != nil {
+			WriteProblem(w, validationProblem(err))
+			return
+		}
+
+		resp, err := fn(r.Context(), req)
+		if err != nil {
+			p := problemFor(err)
+			if p.Instance == "" {
+				p.Instance = r.URL.Path
+			}
+
+			WriteProblem(w, p)
+			return
+		}
+
+		if cfg.status == http.StatusNoContent {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(cfg.status)
+
+		json.NewEncoder(w).Encode(resp)
+	})
+}
+
+// bind decodes the body and the path and query values into req
+func bind(w http.ResponseWriter, r *http.Request, req any, maxBodySize int64) *Problem {
+	if p := decodeBody(w, r, req, maxBodySize); p != nil {
+		return p
+	}
+
+	if err := bindValues(r, req); err != nil {
+		return NewProblem(http.StatusBadRequest, err.Error())
+	}
+
+	return nil
+}
+
+// decodeBody decodes the JSON body into req, an empty body is left alone
+func decodeBody(w http.ResponseWriter, r *http.Request, req any, maxBodySize int64) *Problem {
+	if r.Body == nil || r.Body == http.NoBody {
+		return nil
+	}
+
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil || mt != "application/json" && !strings.HasSuffix(mt, "+json") {
+			return NewProblem(http.StatusUnsupportedMediaType, fmt.Sprintf("content type %q is not JSON", ct))
+		}
+	}
+
+	dec := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodySize))
+	dec.DisallowUnknownFields()
+
+	err := dec.Decode(req)
+	if errors.Is(err, io.EOF) {
+		return nil
+	}
+
+	if err == nil {
+		// a second value, or garbage, after the first one
+		if dec.Decode(&struct{}{}) != io.EOF {
+			return NewProblem(http.StatusBadRequest, "body must hold a single JSON value")
+		}
+
+		return nil
+	}
+
+	var (
+		maxErr    *http.MaxBytesError
+		syntaxErr *json.SyntaxError
+		typeErr   *json.UnmarshalTypeError
+	)
+
+	switch {
+	case errors.As(err, &maxErr):
+		return NewProblem(http.StatusRequestEntityTooLarge, fmt.Sprintf("body exceeds %d bytes", maxErr.Limit))
+	case errors.As(err, &syntaxErr):
+		return NewProblem(http.StatusBadRequest, fmt.Sprintf("malformed JSON at offset %d", syntaxErr.Offset))
+	case errors.As(err, &typeErr):
+		return NewProblem(http.StatusBadRequest, fmt.Sprintf("field %q must be %s", typeErr.Field, typeErr.Type))
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		return NewProblem(http.StatusBadRequest, "malformed JSON")
+	}
+
+	// unknown fields have no error type, the message names the field
+	return NewProblem(http.StatusBadRequest, strings.TrimPrefix(err.Error(), "json: "))
+}
+
+// bindValues sets the fields of req tagged with path and query, req must
+// be a pointer, values of other types than structs are left alone
+func bindValues(r *http.Request, req any) error {
+	v := reflect.ValueOf(req).Elem()
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+
+	t := v.Type()
+	query := r.URL.Query()
+
+	for i := range t.NumField() {
+		f := t.Field(i)
+		if !f.IsExported() {
+			continue
+		}
+
+		var (
+			name, value string
+			ok          bool
+		)
+
+		if name, ok = f.Tag.Lookup("path"); ok {
+			value = r.PathValue(name)
+			ok = value != ""
+		} else if name, ok = f.Tag.Lookup("query"); ok {
+			ok = query.Has(name)
+			value = query.Get(name)
+		}
+
+		if !ok {
+			continue
+		}
+
+		if err := setValue(v.Field(i), value); err != nil {
+			return fmt.Errorf("%s %q: %w", name, value, err)
+		}
+	}
+
+	return nil
+}
+
+// setValue parses s into the string, bool or number field
+func setValue(field reflect.Value, s string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return errors.New("must be a boolean")
+		}
+		field.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, field.Type().Bits())
+		if err != nil {
+			return errors.New("must be an integer")
+		}
+		field.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, field.Type().Bits())
+		if err != nil {
+			return errors.New("must be a non negative integer")
+		}
+		field.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, field.Type().Bits())
+		if err != nil {
+			return errors.New("must be a number")
+		}
+		field.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported field type %s", field.Type())
+	}
+
+	return nil
+}
+
+// validate runs Validate if the request implements validator.Validator,
+// req is a pointer, so both value and pointer receivers count
+func validate(req any) error {
+	if v, ok := req.(validator.Validator); ok {
+		return v.Validate()
+	}
+
+	return nil
+}
+
+// validationProblem lists the errors joined in err, with errors.Join
+func validationProblem(err error) *Problem {
+	p := NewProblem(http.StatusUnprocessableEntity, "the request failed validation")
+
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		for _, e := range joined.Unwrap() {
+			p.Errors = append(p.Errors, e.Error())
+		}
+	} else {
+		p.Errors = []string{err.Error()}
+	}
+
+	return p
+}
@@ -0,0 +1,225 @@
+package httpext_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tanveerprottoy/advanced-go/httpext"
+)
+
+type updateProduct struct {
+	ID     int    `json:"-" path:"id"`
+	DryRun bool   `json:"-" query:"dry_run"`
+	Name   string `json:"name"`
+	Price  int    `json:"price"`
+}
+
+func (u updateProduct) Validate() error {
+	var errs []error
+
+	if u.Name == "" {
+		errs = append(errs, errors.New("name is required"))
+	}
+
+	if u.Price <= 0 {
+		errs = append(errs, errors.New("price must be positive"))
+	}
+
+	return errors.Join(errs...)
+}
+
+func TestHandle(t *testing.T) {
+	mux := http.NewServeMux()
+
+	mux.Handle("PUT /products/{id}", httpext.Handle(func(ctx context.Context, req updateProduct) (product, error) {
+		switch req.ID {
+		case 404:
+			return product{}, fmt.Errorf("product %d: %w", req.ID, httpext.ErrNotFound)
+		case 500:
+			return product{}, errors.New("db: connection refused")
+		}
+
+		name := req.Name
+		if req.DryRun {
+			name += " (dry run)"
+		}
+
+		return product{ID: req.ID, Name: name}, nil
+	}, httpext.WithMaxBodySize(64)))
+
+	tests := []struct {
+		name        string
+		target      string
+		contentType string
+		body        string
+		code        int
+		want        string
+	}{
+		{
+			name: "binds body, path and query", target: "/products/7?dry_run=true",
+			body: `{"name":"pen","price":2}`,
+			code: http.StatusOK, want: `{"id":7,"name":"pen (dry run)"}`,
+		},
+		{
+			name: "unknown field", target: "/products/7",
+			body: `{"name":"pen","price":2,"color":"red"}`,
+			code: http.StatusBadRequest, want: `"detail":"unknown field \"color\""`,
+		},
+		{
+			name: "trailing data", target: "/products/7",
+			body: `{"name":"pen","price":2}{}`,
+			code: http.StatusBadRequest, want: `"detail":"body must hold a single JSON value"`,
+		},
+		{
+			name: "wrong type", target: "/products/7",
+			body: `{"name":"pen","price":"two"}`,
+			code: http.StatusBadRequest, want: `"detail":"field \"price\" must be int"`,
+		},
+		{
+			name: "too large", target: "/products/7",
+			body: `{"name":"` + strings.Repeat("x", 64) + `","price":2}`,
+			code: http.StatusRequestEntityTooLarge,
+		},
+		{
+			name: "not JSON", target: "/products/7", contentType: "text/plain",
+			body: `name=pen`,
+			code: http.StatusUnsupportedMediaType,
+		},
+		{
+			name: "invalid path value", target: "/products/seven",
+			body: `{"name":"pen","price":2}`,
+			code: http.StatusBadRequest, want: `"detail":"id \"seven\": must be an integer"`,
+		},
+		{
+			name: "validation", target: "/products/7",
+			body: `{"price":0}`,
+			code: http.StatusUnprocessableEntity, want: `"errors":["name is required","price must be positive"]`,
+		},
+		{
+			name: "sentinel error", target: "/products/404",
+			body: `{"name":"pen","price":2}`,
+			code: http.StatusNotFound, want: `"detail":"product 404","instance":"/products/404"`,
+		},
+		{
+			name: "internal error is not leaked", target: "/products/500",
+			body: `{"name":"pen","price":2}`,
+			code: http.StatusInternalServerError, want: `{"title":"Internal Server Error","status":500,"instance":"/products/500"}`,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPut, tc.target, strings.NewReader(tc.body))
+
+			ct := tc.contentType
+			if ct == "" {
+				ct = "application/json"
+			}
+			req.Header.Set("Content-Type", ct)
+
+			rec := httptest.NewRecorder()
+			mux.ServeHTTP(rec, req)
+
+			if rec.Code != tc.code {
+				t.Fatalf("status = %d; want %d, body %s", rec.Code, tc.code, rec.Body)
+			}
+
+			if !strings.Contains(rec.Body.String(), tc.want) {
+				t.Errorf("body = %s; want it to contain %s", rec.Body, tc.want)
+			}
+
+			if rec.Code >= 400 && rec.Header().Get("Content-Type") != httpext.ContentTypeProblem {
+				t.Errorf("Content-Type = %q; want %s", rec.Header().Get("Content-Type"), httpext.ContentTypeProblem)
+			}
+		})
+	}
+}
+
+func TestHandleProblemAndStatus(t *testing.T) {
+	conflict := &httpext.Problem{
+		Type:   "https://example.com/problems/out-of-stock",
+		Title:  "Out of stock",
+		Status: http.StatusConflict,
+	}
+
+	h := httpext.Handle(func(ctx context.Context, req struct{}) (struct{}, error) {
+		return struct{}{}, conflict
+	})
+
+	for range 2 {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+		var p httpext.Problem
+		json.Unmarshal(rec.Body.Bytes(), &p)
+
+		if rec.Code != http.StatusConflict || p.Type != conflict.Type || p.Instance != "/orders" {
+			t.Errorf("response = %d %+v; want the problem with the instance", rec.Code, p)
+		}
+	}
+
+	if conflict.Instance != "" {
+		t.Errorf("the returned problem was modified")
+	}
+
+	h = httpext.Handle(func(ctx context.Context, req struct{}) (*struct{}, error) {
+		return nil, nil
+	}, httpext.WithStatus(http.StatusNoContent))
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodDelete, "/orders/1", nil))
+
+	if rec.Code != http.StatusNoContent || rec.Body.Len() != 0 {
+		t.Errorf("response = %d %q; want 204 without body", rec.Code, rec.Body)
+	}
+}
+
+// quotaError carries its status, and a detail safe to send
+type quotaError struct{}
+
+func (e quotaError) Error() string        { return "quota: select from quotas: connection refused" }
+func (e quotaError) StatusCode() int      { return http.StatusTooManyRequests }
+func (e quotaError) PublicDetail() string { return "quota exceeded" }
+
+// internalStatusError carries its status, its message is internal
+type internalStatusError struct{}
+
+func (internalStatusError) Error() string   { return "open /srv/data/quota.db: permission denied" }
+func (internalStatusError) StatusCode() int { return http.StatusServiceUnavailable }
+
+func TestHandleErrorDetail(t *testing.T) {
+	tests := []struct {
+		err        error
+		wantStatus int
+		wantDetail string
+	}{
+		{fmt.Errorf("checking quota: %w", quotaError{}), http.StatusTooManyRequests, "quota exceeded"},
+		{fmt.Errorf("loading: %w", internalStatusError{}), http.StatusServiceUnavailable, ""},
+		{fmt.Errorf("product 3: %w", httpext.ErrNotFound), http.StatusNotFound, "product 3"},
+		// the database error after the sentinel stays on the server
+		{fmt.Errorf("%w: %w", httpext.ErrNotFound, errors.New("sql: no rows in result set")), http.StatusNotFound, ""},
+		{httpext.ErrConflict, http.StatusConflict, ""},
+	}
+
+	for _, tc := range tests {
+		h := httpext.Handle(func(ctx context.Context, req struct{}) (struct{}, error) {
+			return struct{}{}, tc.err
+		})
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/orders", nil))
+
+		var p httpext.Problem
+		json.Unmarshal(rec.Body.Bytes(), &p)
+
+		if rec.Code != tc.wantStatus || p.Detail != tc.wantDetail {
+			t.Errorf("response = %d %+v; want %d with detail %q", rec.Code, p, tc.wantStatus, tc.wantDetail)
+		}
+	}
+}
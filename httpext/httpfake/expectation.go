@@ -0,0 +1,258 @@
+package httpfake
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// BodyMatcher reports whether a request body satisfies an expectation
+type BodyMatcher func(body []byte) bool
+
+// BodyEquals matches a request body that is exactly s
+func BodyEquals(s string) BodyMatcher {
+	return func(body []byte) bool {
+		return string(body) == s
+	}
+}
+
+// BodyContains matches a request body that contains s
+func BodyContains(s string) BodyMatcher {
+	return func(body []byte) bool {
+		return bytes.Contains(body, []byte(s))
+	}
+}
+
+// BodyJSON matches a request body that is semantically equal to the
+// json document s, so key order and whitespace do not matter
+func BodyJSON(s string) BodyMatcher {
+	var want any
+	if err := json.Unmarshal([]byte(s), &want); err != nil {
+		panic(fmt.Sprintf("httpfake: invalid json in BodyJSON: %v", err))
+	}
+
+	return func(body []byte) bool {
+		var got any
+		if err := json.Unmarshal(body, &got); err != nil {
+			return false
+		}
+
+		return reflect.DeepEqual(want, got)
+	}
+}
+
+// Response is a canned response returned by the fake server
+type Response struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Delay is waited before the response is written, the wait ends early
+	// if the client goes away
+	Delay time.Duration
+}
+
+// WithDelay returns a copy of the response that is written after d
+func (r Response) WithDelay(d time.Duration) Response {
+	r.Delay = d
+	return r
+}
+
+// WithHeader returns a copy of the response with the header key set to value
+func (r Response) WithHeader(key, value string) Response {
+	h := r.Header.Clone()
+	if h == nil {
+		h = make(http.Header)
+	}
+
+	h.Set(key, value)
+	r.Header = h
+
+	return r
+}
+
+// Status returns a response with the status code and no body
+func Status(code int) Response {
+	return Response{Status: code}
+}
+
+// Text returns a text/plain response
+func Text(code int, body string) Response {
+	return Response{
+		Status: code,
+		Header: http.Header{"Content-Type": {"text/plain; charset=utf-8"}},
+		Body:   []byte(body),
+	}
+}
+
+// JSON returns an application/json response with v encoded as the body,
+// it panics if v cannot be encoded as this is a programming error in the test
+func JSON(code int, v any) Response {
+	b, err := json.Marshal(v)
+	if err != nil {
+		panic(fmt.Sprintf("httpfake: cannot encode json response: %v", err))
+	}
+
+	return Response{
+		Status: code,
+		Header: http.Header{"Content-Type": {"application/json"}},
+		Body:   b,
+	}
+}
+
+// Expectation describes a request the fake server expects to receive
+// and the responses it sends back
+// an Expectation is built with Expect and the chained methods below,
+// it must not be modified after it is passed to the server
+type Expectation struct {
+	method string
+	path   string
+	query  map[string]string
+	header map[string]string
+	body   []BodyMatcher
+
+	responses []Response
+
+	// times is the exact number of calls expected, 0 means at least once
+	times    int
+	anyTimes bool
+	calls    int
+}
+
+// Expect starts an expectation for a request with method and path
+func Expect(method, path string) *Expectation {
+	return &Expectation{
+		method: method,
+		path:   path,
+		query:  make(map[string]string),
+		header: make(map[string]string),
+	}
+}
+
+// Query requires the query parameter key to have value
+func (e *Expectation) Query(key, value string) *Expectation {
+	e.query[key] = value
+	return e
+}
+
+// Header requires the request header key to have value
+func (e *Expectation) Header(key, value string) *Expectation {
+	e.header[http.CanonicalHeaderKey(key)] = value
+	return e
+}
+
+// Body requires the request body to satisfy every matcher
+func (e *Expectation) Body(matchers ...BodyMatcher) *Expectation {
+	e.body = append(e.body, matchers...)
+	return e
+}
+
+// Respond appends responses to the response sequence
+// the responses are returned in order, once the sequence is exhausted
+// the last one is repeated for every further call
+func (e *Expectation) Respond(responses ...Response) *Expectation {
+	e.responses = append(e.responses, responses...)
+	return e
+}
+
+// Times requires the expectation to be matched exactly n times
+// further matching requests fall through to the next expectation
+func (e *Expectation) Times(n int) *Expectation {
+	e.times = n
+	e.anyTimes = false
+	return e
+}
+
+// AnyTimes allows the expectation to be matched any number of times,
+// including never
+func (e *Expectation) AnyTimes() *Expectation {
+	e.times = 0
+	e.anyTimes = true
+	return e
+}
+
+// String describes the expectation for failure messages
+func (e *Expectation) String() string {
+	var sb strings.Builder
+
+	sb.WriteString(e.method)
+	sb.WriteString(" ")
+	sb.WriteString(e.path)
+
+	for k, v := range e.query {
+		fmt.Fprintf(&sb, " query[%s=%s]", k, v)
+	}
+
+	for k, v := range e.header {
+		fmt.Fprintf(&sb, " header[%s: %s]", k, v)
+	}
+
+	if len(e.body) > 0 {
+		fmt.Fprintf(&sb, " body[%d matchers]", len(e.body))
+	}
+
+	return sb.String()
+}
+
+// exhausted reports whether the expectation accepts no more calls
+func (e *Expectation) exhausted() bool {
+	return e.times > 0 && e.calls >= e.times
+}
+
+// unmet returns a description of why the expectation was not satisfied,
+// or an empty string if it was
+func (e *Expectation) unmet() string {
+	switch {
+	case e.anyTimes:
+		return ""
+	case e.times > 0 && e.calls != e.times:
+		return fmt.Sprintf("%s: expected %d calls, got %d", e, e.times, e.calls)
+	case e.times == 0 && e.calls == 0:
+		return fmt.Sprintf("%s: expected at least one call, got none", e)
+	}
+
+	return ""
+}
+
+func (e *Expectation) matches(r *http.Request, body []byte) bool {
+	if e.method != r.Method || e.path != r.URL.Path {
+		return false
+	}
+
+	q := r.URL.Query()
+	for k, v := range e.query {
+		if !q.Has(k) || q.Get(k) != v {
+			return false
+		}
+	}
+
+	for k, v := range e.header {
+		if r.Header.Get(k) != v {
+			return false
+		}
+	}
+
+	for _, m := range e.body {
+		if !m(body) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// next records a call and returns the response for it
+func (e *Expectation) next() Response {
+	e.calls++
+
+	if len(e.responses) == 0 {
+		return Status(http.StatusOK)
+	}
+
+	i := min(e.calls, len(e.responses)) - 1
+
+	return e.responses[i]
+}
@@ -0,0 +1,158 @@
+/*
+package httpfake provides an in-process fake HTTP server for tests.
+The server is configured with a declarative list of expectations, each
+one matching requests by method, path, query, header and body and
+replying with a sequence of canned responses.
+Call counts are verified and unmet expectations are reported when the
+test finishes.
+
+	srv := httpfake.NewServer(t,
+		httpfake.Expect(http.MethodGet, "/api/v1/products").
+			Query("page", "1").
+			Respond(httpfake.JSON(http.StatusOK, products)).
+			Times(1),
+	)
+
+	resp, err := http.Get(srv.URL() + "/api/v1/products?page=1")
+*/
+package httpfake
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Server is a fake HTTP server backed by httptest.Server
+type Server struct {
+	t   testing.TB
+	srv *httptest.Server
+
+	mu           sync.Mutex
+	expectations []*Expectation
+	unexpected   []string
+}
+
+// NewServer starts a fake server that serves exps
+// the server is closed and verified in t.Cleanup
+func NewServer(t testing.TB, exps ...*Expectation) *Server {
+	t.Helper()
+
+	s := &Server{
+		t:            t,
+		expectations: exps,
+	}
+
+	s.srv = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+
+	t.Cleanup(func() {
+		s.srv.Close()
+		s.verify()
+	})
+
+	return s
+}
+
+// URL returns the base url of the server, of the form http://ipaddr:port
+func (s *Server) URL() string {
+	return s.srv.URL
+}
+
+// Client returns an http client configured to talk to the server
+func (s *Server) Client() *http.Client {
+	return s.srv.Client()
+}
+
+// Expect adds expectations to a running server
+func (s *Server) Expect(exps ...*Expectation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.expectations = append(s.expectations, exps...)
+}
+
+// Calls returns the number of requests matched by e so far
+func (s *Server) Calls(e *Expectation) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return e.calls
+}
+
+// verify reports unmet expectations and unexpected requests to the test
+func (s *Server) verify() {
+	s.t.Helper()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.expectations {
+		if msg := e.unmet(); msg != "" {
+			s.t.Errorf("httpfake: unmet expectation %s", msg)
+		}
+	}
+
+	for _, msg := range s.unexpected {
+		s.t.Errorf("httpfake: unexpected request %s", msg)
+	}
+}
+
+// match finds the first expectation that accepts the request and
+// records the call, it returns false if there is none
+func (s *Server) match(r *http.Request, body []byte) (Response, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, e := range s.expectations {
+		if e.exhausted() || !e.matches(r, body) {
+			continue
+		}
+
+		return e.next(), true
+	}
+
+	s.unexpected = append(s.unexpected, fmt.Sprintf("%s %s", r.Method, r.URL.RequestURI()))
+
+	return Response{}, false
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, ok := s.match(r, body)
+	if !ok {
+		http.Error(w, "httpfake: no expectation matched the request", http.StatusNotImplemented)
+		return
+	}
+
+	if resp.Delay > 0 {
+		t := time.NewTimer(resp.Delay)
+		defer t.Stop()
+
+		select {
+		case <-t.C:
+		case <-r.Context().Done():
+			// the client gave up, nobody is left to read the response
+			return
+		}
+	}
+
+	for k, v := range resp.Header {
+		w.Header()[k] = v
+	}
+
+	if resp.Status == 0 {
+		resp.Status = http.StatusOK
+	}
+
+	w.WriteHeader(resp.Status)
+	w.Write(resp.Body)
+}
@@ -0,0 +1,122 @@
+package httpfake_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/tanveerprottoy/advanced-go/httpext/httpfake"
+)
+
+// recorderTB captures failures and cleanups so the verification done
+// by the fake server can itself be asserted
+type recorderTB struct {
+	testing.TB
+	errors   []string
+	cleanups []func()
+}
+
+func (r *recorderTB) Helper() {}
+
+func (r *recorderTB) Errorf(format string, args ...any) {
+	r.errors = append(r.errors, fmt.Sprintf(format, args...))
+}
+
+func (r *recorderTB) Cleanup(f func()) {
+	r.cleanups = append(r.cleanups, f)
+}
+
+func (r *recorderTB) finish() {
+	for i := len(r.cleanups) - 1; i >= 0; i-- {
+		r.cleanups[i]()
+	}
+}
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("http.Get(%s): %v", url, err)
+	}
+
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+
+	return resp.StatusCode, string(b)
+}
+
+func TestResponseSequence(t *testing.T) {
+	srv := httpfake.NewServer(t,
+		httpfake.Expect(http.MethodGet, "/items").
+			Respond(
+				httpfake.Status(http.StatusServiceUnavailable),
+				httpfake.Text(http.StatusOK, "ok"),
+			).
+			Times(3),
+	)
+
+	exp := []int{http.StatusServiceUnavailable, http.StatusOK, http.StatusOK}
+	for i, want := range exp {
+		if got, _ := get(t, srv.URL()+"/items"); got != want {
+			t.Errorf("call %d: status = %d; want %d", i, got, want)
+		}
+	}
+}
+
+func TestExhaustedFallsThrough(t *testing.T) {
+	srv := httpfake.NewServer(t,
+		httpfake.Expect(http.MethodGet, "/items").Query("page", "1").
+			Respond(httpfake.Text(http.StatusOK, "first")).
+			Times(1),
+		httpfake.Expect(http.MethodGet, "/items").
+			Respond(httpfake.Text(http.StatusOK, "rest")),
+	)
+
+	for _, want := range []string{"first", "rest"} {
+		if _, got := get(t, srv.URL()+"/items?page=1"); got != want {
+			t.Errorf("body = %q; want %q", got, want)
+		}
+	}
+}
+
+func TestDelayStopsOnClientCancel(t *testing.T) {
+	srv := httpfake.NewServer(t,
+		httpfake.Expect(http.MethodGet, "/slow").
+			Respond(httpfake.Status(http.StatusOK).WithDelay(time.Minute)),
+	)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL()+"/slow", nil)
+
+	if _, err := http.DefaultClient.Do(req); err == nil {
+		t.Fatalf("expected the request to time out")
+	}
+}
+
+func TestVerify(t *testing.T) {
+	rec := &recorderTB{TB: t}
+
+	srv := httpfake.NewServer(rec,
+		httpfake.Expect(http.MethodGet, "/once").Times(1),
+		httpfake.Expect(http.MethodGet, "/never"),
+		httpfake.Expect(http.MethodGet, "/optional").AnyTimes(),
+	)
+
+	get(t, srv.URL()+"/once")
+	get(t, srv.URL()+"/once")
+
+	rec.finish()
+
+	// /once is exhausted after the first call so the second one is
+	// unexpected, /never was never called
+	if len(rec.errors) != 2 {
+		t.Fatalf("got %d errors; want 2: %q", len(rec.errors), rec.errors)
+	}
+}
@@ -0,0 +1,140 @@
+package httpext
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+)
+
+// ContentTypeProblem is the media type of RFC 9457 problem details
+const ContentTypeProblem = "application/problem+json"
+
+// errors a handler returns to pick the status of the response, wrap them
+// to add a detail, like fmt.Errorf("product %d: %w", id, ErrNotFound),
+// only the text before the sentinel is sent
+var (
+	ErrBadRequest   = errors.New("httpext: bad request")
+	ErrUnauthorized = errors.New("httpext: unauthorized")
+	ErrForbidden    = errors.New("httpext: forbidden")
+	ErrNotFound     = errors.New("httpext: not found")
+	ErrConflict     = errors.New("httpext: conflict")
+	ErrValidation   = errors.New("httpext: validation failed")
+)
+
+// sentinelStatus maps the errors above to their status
+var sentinelStatus = []struct {
+	err    error
+	status int
+}{
+	{ErrBadRequest, http.StatusBadRequest},
+	{ErrUnauthorized, http.StatusUnauthorized},
+	{ErrForbidden, http.StatusForbidden},
+	{ErrNotFound, http.StatusNotFound},
+	{ErrConflict, http.StatusConflict},
+	{ErrValidation, http.StatusUnprocessableEntity},
+}
+
+// Problem is an RFC 9457 problem details object, a handler may return it,
+// or an error wrapping it, to control the response fully
+type Problem struct {
+	Type     string `json:"type,omitempty"` // a URI, about:blank when empty
+	Title    string `json:"title,omitempty"`
+	Status   int    `json:"status,omitempty"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	// Errors lists the individual problems, like the failed validations
+	Errors []string `json:"errors,omitempty"`
+}
+
+func (p *Problem) Error() string {
+	if p.Detail != "" {
+		return p.Title + ": " + p.Detail
+	}
+
+	return p.Title
+}
+
+// NewProblem returns a problem with the status and its text as title
+func NewProblem(status int, detail string) *Problem {
+	return &Problem{
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+	}
+}
+
+// WriteProblem writes p as application/problem+json
+func WriteProblem(w http.ResponseWriter, p *Problem) {
+	status := p.Status
+	if status == 0 {
+		status = http.StatusInternalServerError
+	}
+
+	w.Header().Set("Content-Type", ContentTypeProblem)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(p)
+}
+
+// statusCoder is implemented by errors that carry their status
+type statusCoder interface {
+	StatusCode() int
+}
+
+// publicDetailer is implemented by errors whose detail may be sent, the
+// message of a statusCoder is not, it may wrap internals
+type publicDetailer interface {
+	PublicDetail() string
+}
+
+// problemFor maps an error returned by a handler to the problem sent
+// the detail of errors without a known status is not sent, it may hold
+// internals the client must not see
+func problemFor(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		// a copy, the handler may return the same problem every time
+		cp := *p
+		return &cp
+	}
+
+	var sc statusCoder
+	if errors.As(err, &sc) {
+		detail := ""
+
+		var pd publicDetailer
+		if errors.As(err, &pd) {
+			detail = pd.PublicDetail()
+		}
+
+		return NewProblem(sc.StatusCode(), detail)
+	}
+
+	for _, s := range sentinelStatus {
+		if errors.Is(err, s.err) {
+			return NewProblem(s.status, sentinelDetail(err, s.err))
+		}
+	}
+
+	return NewProblem(http.StatusInternalServerError, "")
+}
+
+// sentinelDetail is the text the handler wrote before the sentinel, like
+// "product 3" of fmt.Errorf("product 3: %w", ErrNotFound), the text of
+// the sentinel is left out, and there is no detail when anything follows
+// it, like a database error in fmt.Errorf("%w: %w", ErrNotFound, err)
+func sentinelDetail(err, sentinel error) string {
+	var pd publicDetailer
+	if errors.As(err, &pd) {
+		return pd.PublicDetail()
+	}
+
+	detail, ok := strings.CutSuffix(err.Error(), ": "+sentinel.Error())
+	if !ok {
+		return ""
+	}
+
+	return detail
+}
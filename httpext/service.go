@@ -1,6 +1,7 @@
 package httpext
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -8,16 +9,88 @@ import (
 	"net/http"
 )
 
+type ServiceOption func(*serviceConfig)
+
+// WithRequestCompression compresses request bodies with encoding, gzip or deflate,
+// and sets the Content-Encoding header
+// bodies smaller than minSize bytes are sent as is, compressing them
+// usually costs more than it saves
+func WithRequestCompression(encoding string, minSize int) ServiceOption {
+	return func(c *serviceConfig) {
+		c.compression = encoding
+		c.compressionMinSize = minSize
+	}
+}
+
+// WithMaxDecompressedSize caps the size of a decompressed response body
+// defaults to DefaultMaxDecompressedSize
+func WithMaxDecompressedSize(n int64) ServiceOption {
+	return func(c *serviceConfig) {
+		c.maxDecompressedSize = n
+	}
+}
+
+// serviceConfig holds the options of the service, it is kept apart from
+// the generic service type so the options do not need type parameters
+type serviceConfig struct {
+	compression         string
+	compressionMinSize  int
+	maxDecompressedSize int64
+}
+
 // service implements the Requester interface
 // it makes http requests using the client
 type service[R, E any] struct {
 	client Client
+	cfg    serviceConfig
 }
 
-func NewService[R, E any](client Client) *service[R, E] {
-	return &service[R, E]{
+func NewService[R, E any](client Client, opts ...ServiceOption) *service[R, E] {
+	s := &service[R, E]{
 		client: client,
+		cfg: serviceConfig{
+			maxDecompressedSize: DefaultMaxDecompressedSize,
+		},
+	}
+
+	// apply options
+	for _, opt := range opts {
+		opt(&s.cfg)
+	}
+
+	return s
+}
+
+// compressRequestBody compresses the body if compression is enabled and the
+// body is large enough, it returns the body to send and the header to use
+func (s *service[R, E]) compressRequestBody(header http.Header, body io.Reader) (http.Header, io.Reader, error) {
+	if s.cfg.compression == "" || body == nil {
+		return header, body, nil
+	}
+
+	b, err := io.ReadAll(body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(b) < s.cfg.compressionMinSize {
+		return header, bytes.NewReader(b), nil
+	}
+
+	compressed, err := compressBody(s.cfg.compression, b)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// clone the header so the caller's one is not modified
+	header = header.Clone()
+	if header == nil {
+		header = make(http.Header)
 	}
+
+	header.Set("Content-Encoding", s.cfg.compression)
+
+	return header, bytes.NewReader(compressed), nil
 }
 
 func (s *service[R, E]) buildRequest(
@@ -57,6 +130,11 @@ func (s *service[R, E]) Request(
 		defer cancel()
 	}
 
+	header, body, err := s.compressRequestBody(header, body)
+	if err != nil {
+		return nil, nil, err
+	}
+
 	req, err := s.buildRequest(ctx, method, url, header, body)
 	if err != nil {
 		return nil, nil, err
@@ -67,7 +145,13 @@ func (s *service[R, E]) Request(
 		return nil, nil, err
 	}
 
-	defer resp.Body.Close()
+	// closes the decoder too once decompressResponse replaced the body
+	defer func() { resp.Body.Close() }()
+
+	// decode the body if the transport left it compressed
+	if err := decompressResponse(resp, s.cfg.maxDecompressedSize); err != nil {
+		return nil, nil, err
+	}
 
 	if resp.StatusCode >= http.StatusOK && resp.StatusCode < http.StatusMultipleChoices {
 		// resp ok, parse response body to type
@@ -0,0 +1,78 @@
+package httpext_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/tanveerprottoy/advanced-go/httpext"
+	"github.com/tanveerprottoy/advanced-go/httpext/httpfake"
+)
+
+type product struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+type errorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func TestServiceRequest(t *testing.T) {
+	srv := httpfake.NewServer(t,
+		httpfake.Expect(http.MethodPost, "/api/v1/products").
+			Header("Content-Type", "application/json").
+			Body(httpfake.BodyJSON(`{"name": "apple"}`)).
+			Respond(httpfake.JSON(http.StatusCreated, product{ID: 1, Name: "apple"})).
+			Times(1),
+		httpfake.Expect(http.MethodGet, "/api/v1/products/2").
+			Respond(httpfake.JSON(http.StatusNotFound, errorResponse{Code: "not_found", Message: "product not found"})).
+			Times(1),
+	)
+
+	client := httpext.NewCustomClient(httpext.Config{Timeout: 5 * time.Second})
+	svc := httpext.NewService[product, errorResponse](client)
+
+	tests := []struct {
+		name    string
+		method  string
+		path    string
+		body    string
+		expRes  *product
+		expErr  *errorResponse
+		wantErr bool
+	}{
+		{"decodes success response", http.MethodPost, "/api/v1/products", `{"name":"apple"}`, &product{ID: 1, Name: "apple"}, nil, false},
+		{"decodes error response", http.MethodGet, "/api/v1/products/2", "", nil, &errorResponse{Code: "not_found", Message: "product not found"}, true},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			header := make(http.Header)
+			header.Set("Content-Type", "application/json")
+
+			var body io.Reader
+			if tc.body != "" {
+				body = strings.NewReader(tc.body)
+			}
+
+			res, e, err := svc.Request(context.Background(), tc.method, srv.URL()+tc.path, header, body, false)
+
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("Request() err = %v; wantErr %v", err, tc.wantErr)
+			}
+
+			if (res == nil) != (tc.expRes == nil) || res != nil && *res != *tc.expRes {
+				t.Errorf("Request() res = %v; want %v", res, tc.expRes)
+			}
+
+			if (e == nil) != (tc.expErr == nil) || e != nil && *e != *tc.expErr {
+				t.Errorf("Request() e = %v; want %v", e, tc.expErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package websocket
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+// Dial opens a websocket connection to a ws:// or wss:// URL, ctx bounds
+// the dial and the handshake, not the connection
+// when the server refuses the handshake the response is returned with
+// ErrBadHandshake, its body holds the start of what the server sent
+func Dial(ctx context.Context, rawURL string, opts ...Option) (*Conn, *http.Response, error) {
+	cfg := newConfig(opts)
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var port string
+
+	switch u.Scheme {
+	case "ws":
+		u.Scheme, port = "http", "80"
+	case "wss":
+		u.Scheme, port = "https", "443"
+	default:
+		return nil, nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+
+	address := u.Host
+	if u.Port() == "" {
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+
+	dial := cfg.netDial
+	if dial == nil {
+		dial = (&net.Dialer{}).DialContext
+	}
+
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if u.Scheme == "https" {
+		tlsConfig := cfg.tlsConfig.Clone()
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{}
+		}
+
+		if tlsConfig.ServerName == "" {
+			tlsConfig.ServerName = u.Hostname()
+		}
+
+		tc := tls.Client(conn, tlsConfig)
+		if err := tc.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+
+		conn = tc
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		conn.SetDeadline(aLongTimeAgo)
+	})
+
+	br, subprotocol, res, err := handshake(conn, u, cfg)
+
+	if !stop() && err == nil {
+		err = context.Cause(ctx)
+	}
+
+	if err != nil {
+		conn.Close()
+		return nil, res, err
+	}
+
+	return newConn(conn, br, true, subprotocol, cfg), res, nil
+}
+
+// handshake sends the opening request and checks the response, RFC 6455
+// section 4.1, it returns the reader holding what follows the response
+// and the subprotocol the server picked
+func handshake(conn net.Conn, u *url.URL, cfg *config) (*bufio.Reader, string, *http.Response, error) {
+	var b [16]byte
+	rand.Read(b[:])
+	key := base64.StdEncoding.EncodeToString(b[:])
+
+	req := &http.Request{
+		Method:     http.MethodGet,
+		URL:        u,
+		Host:       u.Host,
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     make(http.Header),
+	}
+
+	for k, v := range cfg.header {
+		req.Header[k] = slices.Clone(v)
+	}
+
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Sec-WebSocket-Key", key)
+	req.Header.Set("Sec-WebSocket-Version", "13")
+
+	if len(cfg.subprotocols) > 0 {
+		req.Header.Set("Sec-WebSocket-Protocol", strings.Join(cfg.subprotocols, ", "))
+	}
+
+	if err := req.Write(conn); err != nil {
+		return nil, "", nil, err
+	}
+
+	br := bufio.NewReader(conn)
+
+	res, err := http.ReadResponse(br, req)
+	if err != nil {
+		return nil, "", nil, err
+	}
+
+	if res.StatusCode != http.StatusSwitchingProtocols {
+		// keep what was sent, the connection is closed
+		body, _ := io.ReadAll(io.LimitReader(res.Body, 1024))
+		res.Body = io.NopCloser(strings.NewReader(string(body)))
+
+		return nil, "", res, fmt.Errorf("%w: status %s", ErrBadHandshake, res.Status)
+	}
+
+	switch {
+	case !headerHasToken(res.Header, "Upgrade", "websocket"), !headerHasToken(res.Header, "Connection", "upgrade"):
+		return nil, "", res, fmt.Errorf("%w: not a websocket upgrade", ErrBadHandshake)
+	case res.Header.Get("Sec-WebSocket-Accept") != acceptKey(key):
+		return nil, "", res, fmt.Errorf("%w: invalid Sec-WebSocket-Accept", ErrBadHandshake)
+	case res.Header.Get("Sec-WebSocket-Extensions") != "":
+		return nil, "", res, fmt.Errorf("%w: extension not offered", ErrBadHandshake)
+	}
+
+	subprotocol := res.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !slices.Contains(cfg.subprotocols, subprotocol) {
+		return nil, "", res, fmt.Errorf("%w: subprotocol %q not offered", ErrBadHandshake, subprotocol)
+	}
+
+	return br, subprotocol, res, nil
+}
@@ -0,0 +1,612 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+	"unicode/utf8"
+)
+
+// aLongTimeAgo is a deadline in the past, it unblocks I/O right away
+var aLongTimeAgo = time.Unix(1, 0)
+
+// Conn is a websocket connection, made by Accept or Dial
+// one goroutine may read while others write, writes are serialized,
+// control frames go out between the fragments of a message
+// a read or write whose context is done closes the connection, since the
+// position in the stream is lost
+type Conn struct {
+	conn        net.Conn
+	br          *bufio.Reader
+	bw          *bufio.Writer
+	client      bool // frames written are masked, frames read are not
+	subprotocol string
+	readLimit   int64
+
+	readMu   sync.Mutex    // one reader at a time
+	msgSem   chan struct{} // held while a data message is written
+	frameSem chan struct{} // held while a frame is written
+	maskBuf  []byte        // guarded by frameSem
+
+	pingMu  sync.Mutex
+	pings   map[string]chan struct{} // waiting Pings by payload
+	pong    []byte                   // payload of the pong to send next
+	ponging bool                     // writePongs is running
+
+	closeSent atomic.Bool
+	closed    chan struct{}
+	closeOnce sync.Once
+	err       error // why the connection is closed, set before closed is closed
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, client bool, subprotocol string, cfg *config) *Conn {
+	c := &Conn{
+		conn:        conn,
+		br:          br,
+		bw:          bufio.NewWriter(conn),
+		client:      client,
+		subprotocol: subprotocol,
+		readLimit:   cfg.readLimit,
+		msgSem:      make(chan struct{}, 1),
+		frameSem:    make(chan struct{}, 1),
+		pings:       make(map[string]chan struct{}),
+		closed:      make(chan struct{}),
+	}
+
+	if cfg.pingInterval > 0 {
+		go c.keepalive(cfg.pingInterval)
+	}
+
+	return c
+}
+
+// Subprotocol is the subprotocol agreed in the handshake, or ""
+func (c *Conn) Subprotocol() string {
+	return c.subprotocol
+}
+
+// LocalAddr returns the local network address
+func (c *Conn) LocalAddr() net.Addr {
+	return c.conn.LocalAddr()
+}
+
+// RemoteAddr returns the remote network address
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Read reads the next data message, it answers pings and handles the close
+// frames that come before it
+// once the peer closed the connection, Read returns a *CloseError with the
+// status it sent
+func (c *Conn) Read(ctx context.Context) (MessageType, []byte, error) {
+	c.readMu.Lock()
+	defer c.readMu.Unlock()
+
+	select {
+	case <-c.closed:
+		return 0, nil, c.err
+	default:
+	}
+
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetReadDeadline(aLongTimeAgo)
+	})
+	defer stop()
+
+	typ, p, err := c.readMessage()
+	if err != nil {
+		if ctx.Err() != nil && errors.Is(err, os.ErrDeadlineExceeded) {
+			err = context.Cause(ctx)
+		}
+
+		c.closeConn(err)
+
+		return 0, nil, c.err
+	}
+
+	return typ, p, nil
+}
+
+// readMessage reads the frames of the next data message
+func (c *Conn) readMessage() (MessageType, []byte, error) {
+	var (
+		typ     MessageType
+		p       []byte
+		started bool
+	)
+
+	for {
+		h, err := readHeader(c.br)
+		if err != nil {
+			if errors.Is(err, ErrProtocol) {
+				return 0, nil, c.fail(StatusProtocolError, err)
+			}
+
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+				// the peer left without a close frame
+				err = &CloseError{Code: StatusAbnormalClosure}
+			}
+
+			return 0, nil, err
+		}
+
+		if err := c.checkHeader(h); err != nil {
+			return 0, nil, c.fail(StatusProtocolError, err)
+		}
+
+		if h.op.isControl() {
+			if err := c.readControl(h); err != nil {
+				return 0, nil, err
+			}
+
+			continue
+		}
+
+		switch {
+		case h.op == opContinuation && !started:
+			return 0, nil, c.fail(StatusProtocolError, fmt.Errorf("%w: continuation frame outside a message", ErrProtocol))
+		case h.op != opContinuation && started:
+			return 0, nil, c.fail(StatusProtocolError, fmt.Errorf("%w: new message before the last one ended", ErrProtocol))
+		case !started:
+			typ, started = MessageType(h.op), true
+		}
+
+		if int64(len(p))+h.length > c.readLimit {
+			return 0, nil, c.fail(StatusMessageTooBig, ErrReadLimit)
+		}
+
+		n := len(p)
+		p = append(p, make([]byte, h.length)...)
+
+		if _, err := io.ReadFull(c.br, p[n:]); err != nil {
+			return 0, nil, err
+		}
+
+		if h.masked {
+			maskBytes(h.mask, 0, p[n:])
+		}
+
+		if h.fin {
+			if typ == TextMessage && !utf8.Valid(p) {
+				return 0, nil, c.fail(StatusInvalidFramePayloadData, fmt.Errorf("%w: text message is not valid UTF-8", ErrProtocol))
+			}
+
+			return typ, p, nil
+		}
+	}
+}
+
+// checkHeader checks what RFC 6455 section 5 requires of a frame
+func (c *Conn) checkHeader(h header) error {
+	switch {
+	case h.rsv != 0:
+		// no extension was negotiated
+		return fmt.Errorf("%w: reserved bits set", ErrProtocol)
+	case h.masked == c.client:
+		// clients mask every frame, servers none
+		return fmt.Errorf("%w: wrong masking", ErrProtocol)
+	}
+
+	switch h.op {
+	case opContinuation, opText, opBinary:
+		return nil
+	case opClose, opPing, opPong:
+		if !h.fin || h.length > maxControlPayload {
+			return fmt.Errorf("%w: fragmented or oversized control frame", ErrProtocol)
+		}
+
+		return nil
+	default:
+		return fmt.Errorf("%w: unknown opcode %#x", ErrProtocol, byte(h.op))
+	}
+}
+
+// readControl reads a control frame and acts on it, it returns the
+// *CloseError of a close frame
+func (c *Conn) readControl(h header) error {
+	var buf [maxControlPayload]byte
+
+	p := buf[:h.length]
+	if _, err := io.ReadFull(c.br, p); err != nil {
+		return err
+	}
+
+	if h.masked {
+		maskBytes(h.mask, 0, p)
+	}
+
+	switch h.op {
+	case opPing:
+		// answered in the background, a reader blocked on its write while
+		// the peer's reader does the same would never read again
+		// only the last of the pings not answered yet gets a pong, as the
+		// RFC allows
+		c.pingMu.Lock()
+		c.pong = bytes.Clone(p)
+		start := !c.ponging
+		c.ponging = true
+		c.pingMu.Unlock()
+
+		if start {
+			go c.writePongs()
+		}
+
+		return nil
+	case opPong:
+		c.pingMu.Lock()
+		if ch, ok := c.pings[string(p)]; ok {
+			close(ch)
+			delete(c.pings, string(p))
+		}
+		c.pingMu.Unlock()
+
+		return nil
+	}
+
+	ce := &CloseError{Code: StatusNoStatusReceived}
+
+	switch {
+	case len(p) == 1:
+		return c.fail(StatusProtocolError, fmt.Errorf("%w: truncated close status", ErrProtocol))
+	case len(p) >= 2:
+		ce.Code = StatusCode(binary.BigEndian.Uint16(p))
+		ce.Reason = string(p[2:])
+
+		if !ce.Code.validReceived() {
+			return c.fail(StatusProtocolError, fmt.Errorf("%w: invalid close status %d", ErrProtocol, ce.Code))
+		}
+
+		if !utf8.ValidString(ce.Reason) {
+			return c.fail(StatusInvalidFramePayloadData, fmt.Errorf("%w: close reason is not valid UTF-8", ErrProtocol))
+		}
+	}
+
+	// answer with the same status, unless this is the answer to ours
+	if c.closeSent.CompareAndSwap(false, true) {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+
+		c.writeFrame(ctx, true, opClose, closePayload(ce.Code, ""))
+	}
+
+	c.closeConn(ce)
+
+	return ce
+}
+
+// writePongs sends the pending pong until there is none
+func (c *Conn) writePongs() {
+	for {
+		c.pingMu.Lock()
+		p := c.pong
+		c.pong = nil
+		if p == nil {
+			c.ponging = false
+		}
+		c.pingMu.Unlock()
+
+		if p == nil {
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		err := c.writeFrame(ctx, true, opPong, p)
+		cancel()
+
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Write writes a message in one frame
+func (c *Conn) Write(ctx context.Context, typ MessageType, p []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+
+	if err := c.acquire(ctx, c.msgSem); err != nil {
+		return err
+	}
+	defer c.release(c.msgSem)
+
+	if c.closeSent.Load() {
+		return ErrClosed
+	}
+
+	return c.writeFrame(ctx, true, opcode(typ), p)
+}
+
+// Writer returns a writer for a message sent in fragments, every Write is
+// a frame, Close ends the message, other messages wait until then
+func (c *Conn) Writer(ctx context.Context, typ MessageType) (io.WriteCloser, error) {
+	if typ != TextMessage && typ != BinaryMessage {
+		return nil, fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+
+	if err := c.acquire(ctx, c.msgSem); err != nil {
+		return nil, err
+	}
+
+	if c.closeSent.Load() {
+		c.release(c.msgSem)
+		return nil, ErrClosed
+	}
+
+	return &messageWriter{c: c, ctx: ctx, op: opcode(typ)}, nil
+}
+
+// messageWriter writes the fragments of a message
+type messageWriter struct {
+	c      *Conn
+	ctx    context.Context
+	op     opcode // of the next frame
+	closed bool
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrClosed
+	}
+
+	if len(p) == 0 {
+		return 0, nil
+	}
+
+	if err := w.c.writeFrame(w.ctx, false, w.op, p); err != nil {
+		return 0, err
+	}
+
+	w.op = opContinuation
+
+	return len(p), nil
+}
+
+func (w *messageWriter) Close() error {
+	if w.closed {
+		return ErrClosed
+	}
+
+	w.closed = true
+	defer w.c.release(w.c.msgSem)
+
+	return w.c.writeFrame(w.ctx, true, w.op, nil)
+}
+
+// Ping sends a ping and waits for its pong, the pong is read by Read, so
+// Ping needs a reader
+func (c *Conn) Ping(ctx context.Context) error {
+	var b [8]byte
+	rand.Read(b[:])
+
+	key := string(b[:])
+	ch := make(chan struct{})
+
+	c.pingMu.Lock()
+	c.pings[key] = ch
+	c.pingMu.Unlock()
+
+	defer func() {
+		c.pingMu.Lock()
+		delete(c.pings, key)
+		c.pingMu.Unlock()
+	}()
+
+	if err := c.writeFrame(ctx, true, opPing, b[:]); err != nil {
+		return err
+	}
+
+	select {
+	case <-ch:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-c.closed:
+		return c.err
+	}
+}
+
+// keepalive pings the peer every d until the connection is closed
+func (c *Conn) keepalive(d time.Duration) {
+	t := time.NewTicker(d)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+		case <-c.closed:
+			return
+		}
+
+		ctx, cancel := context.WithTimeoutCause(context.Background(), d, ErrKeepaliveTimeout)
+		err := c.Ping(ctx)
+		cancel()
+
+		if errors.Is(err, ErrKeepaliveTimeout) {
+			c.closeConn(err)
+			return
+		}
+	}
+}
+
+// Close starts the close handshake with the status and reason, waits for
+// the peer's answer, at most 5 seconds, and closes the connection
+// a reason longer than 123 bytes is cut
+func (c *Conn) Close(code StatusCode, reason string) error {
+	if !c.closeSent.CompareAndSwap(false, true) {
+		// closing already, or answered the peer's close
+		c.closeConn(ErrClosed)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+	defer cancel()
+
+	if err := c.writeFrame(ctx, true, opClose, closePayload(code, reason)); err != nil {
+		c.closeConn(err)
+		return err
+	}
+
+	if c.readMu.TryLock() {
+		// no one reads, read until the answer, dropping the messages
+		// that come first
+		c.conn.SetReadDeadline(time.Now().Add(closeTimeout))
+
+		for {
+			if _, _, err := c.readMessage(); err != nil {
+				c.closeConn(err)
+				break
+			}
+		}
+
+		c.readMu.Unlock()
+	} else {
+		// the reader gets the answer
+		select {
+		case <-c.closed:
+		case <-ctx.Done():
+		}
+	}
+
+	c.closeConn(ErrClosed)
+
+	return nil
+}
+
+// closePayload is the payload of a close frame
+func closePayload(code StatusCode, reason string) []byte {
+	if code == StatusNoStatusReceived {
+		return nil
+	}
+
+	if len(reason) > maxControlPayload-2 {
+		reason = reason[:maxControlPayload-2]
+	}
+
+	p := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(p, uint16(code))
+	copy(p[2:], reason)
+
+	return p
+}
+
+// fail sends a close frame with the status and closes the connection, it
+// returns err
+func (c *Conn) fail(code StatusCode, err error) error {
+	if c.closeSent.CompareAndSwap(false, true) {
+		ctx, cancel := context.WithTimeout(context.Background(), closeTimeout)
+		defer cancel()
+
+		c.writeFrame(ctx, true, opClose, closePayload(code, ""))
+	}
+
+	c.closeConn(err)
+
+	return err
+}
+
+// writeFrame writes and flushes a frame
+func (c *Conn) writeFrame(ctx context.Context, fin bool, op opcode, p []byte) error {
+	if err := c.acquire(ctx, c.frameSem); err != nil {
+		return err
+	}
+	defer c.release(c.frameSem)
+
+	stop := context.AfterFunc(ctx, func() {
+		c.conn.SetWriteDeadline(aLongTimeAgo)
+	})
+	defer stop()
+
+	err := c.writeFrameLocked(fin, op, p)
+	if err != nil {
+		if ctx.Err() != nil {
+			err = context.Cause(ctx)
+		}
+
+		c.closeConn(err)
+
+		return c.err
+	}
+
+	return nil
+}
+
+func (c *Conn) writeFrameLocked(fin bool, op opcode, p []byte) error {
+	h := header{fin: fin, op: op, masked: c.client, length: int64(len(p))}
+	if c.client {
+		rand.Read(h.mask[:])
+	}
+
+	if err := writeHeader(c.bw, h); err != nil {
+		return err
+	}
+
+	if !c.client {
+		if _, err := c.bw.Write(p); err != nil {
+			return err
+		}
+
+		return c.bw.Flush()
+	}
+
+	// mask a copy, p belongs to the caller
+	if c.maskBuf == nil {
+		c.maskBuf = make([]byte, 4096)
+	}
+
+	pos := 0
+	for len(p) > 0 {
+		n := copy(c.maskBuf, p)
+		pos = maskBytes(h.mask, pos, c.maskBuf[:n])
+
+		if _, err := c.bw.Write(c.maskBuf[:n]); err != nil {
+			return err
+		}
+
+		p = p[n:]
+	}
+
+	return c.bw.Flush()
+}
+
+// acquire takes a semaphore, unless ctx is done or the connection closed
+func (c *Conn) acquire(ctx context.Context, sem chan struct{}) error {
+	select {
+	case <-c.closed:
+		return c.err
+	default:
+	}
+
+	select {
+	case sem <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return context.Cause(ctx)
+	case <-c.closed:
+		return c.err
+	}
+}
+
+func (c *Conn) release(sem chan struct{}) {
+	<-sem
+}
+
+// closeConn closes the connection, the first err is the one kept
+func (c *Conn) closeConn(err error) {
+	c.closeOnce.Do(func() {
+		c.err = err
+		close(c.closed)
+		c.conn.Close()
+	})
+}
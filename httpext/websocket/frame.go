@@ -0,0 +1,132 @@
+package websocket
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// opcode of a frame, RFC 6455 section 5.2
+type opcode byte
+
+const (
+	opContinuation opcode = 0x0
+	opText         opcode = 0x1
+	opBinary       opcode = 0x2
+	opClose        opcode = 0x8
+	opPing         opcode = 0x9
+	opPong         opcode = 0xa
+)
+
+func (op opcode) isControl() bool {
+	return op&0x8 != 0
+}
+
+// maxControlPayload is the largest payload of a control frame
+const maxControlPayload = 125
+
+// header of a frame
+type header struct {
+	fin    bool
+	rsv    byte
+	op     opcode
+	masked bool
+	mask   [4]byte
+	length int64
+}
+
+// readHeader reads a frame header, it refuses lengths that are not in
+// their shortest form or do not fit an int64
+func readHeader(r *bufio.Reader) (header, error) {
+	var (
+		h header
+		b [8]byte
+	)
+
+	if _, err := io.ReadFull(r, b[:2]); err != nil {
+		return h, err
+	}
+
+	h.fin = b[0]&0x80 != 0
+	h.rsv = b[0] & 0x70
+	h.op = opcode(b[0] & 0x0f)
+	h.masked = b[1]&0x80 != 0
+
+	switch n := b[1] & 0x7f; n {
+	case 126:
+		if _, err := io.ReadFull(r, b[:2]); err != nil {
+			return h, err
+		}
+
+		h.length = int64(binary.BigEndian.Uint16(b[:2]))
+		if h.length < 126 {
+			return h, fmt.Errorf("%w: length not in its shortest form", ErrProtocol)
+		}
+	case 127:
+		if _, err := io.ReadFull(r, b[:8]); err != nil {
+			return h, err
+		}
+
+		u := binary.BigEndian.Uint64(b[:8])
+		if u>>63 != 0 || u <= 0xffff {
+			return h, fmt.Errorf("%w: invalid length", ErrProtocol)
+		}
+
+		h.length = int64(u)
+	default:
+		h.length = int64(n)
+	}
+
+	if h.masked {
+		if _, err := io.ReadFull(r, h.mask[:]); err != nil {
+			return h, err
+		}
+	}
+
+	return h, nil
+}
+
+// writeHeader writes a frame header
+func writeHeader(w *bufio.Writer, h header) error {
+	var b [14]byte
+
+	b[0] = byte(h.op) | h.rsv
+	if h.fin {
+		b[0] |= 0x80
+	}
+
+	n := 2
+
+	switch {
+	case h.length < 126:
+		b[1] = byte(h.length)
+	case h.length <= 0xffff:
+		b[1] = 126
+		binary.BigEndian.PutUint16(b[2:], uint16(h.length))
+		n += 2
+	default:
+		b[1] = 127
+		binary.BigEndian.PutUint64(b[2:], uint64(h.length))
+		n += 8
+	}
+
+	if h.masked {
+		b[1] |= 0x80
+		n += copy(b[n:], h.mask[:])
+	}
+
+	_, err := w.Write(b[:n])
+
+	return err
+}
+
+// maskBytes xors b with the key, starting at pos in the key, and returns
+// the position for the bytes that follow, masking twice unmasks
+func maskBytes(key [4]byte, pos int, b []byte) int {
+	for i := range b {
+		b[i] ^= key[(pos+i)&3]
+	}
+
+	return (pos + len(b)) & 3
+}
@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Accept upgrades the request to a websocket connection, the handler then
+// owns the Conn and must Close it, the request's context is done once the
+// handler returns, so it is no context for the Conn
+// a request that is not a valid opening handshake, RFC 6455 section 4.2.1,
+// is answered with an error status and ErrBadHandshake is returned
+func Accept(w http.ResponseWriter, r *http.Request, opts ...Option) (*Conn, error) {
+	cfg := newConfig(opts)
+
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		return nil, refuse(w, http.StatusMethodNotAllowed, "method must be GET")
+	}
+
+	if !headerHasToken(r.Header, "Connection", "upgrade") || !headerHasToken(r.Header, "Upgrade", "websocket") {
+		w.Header().Set("Upgrade", "websocket")
+		w.Header().Set("Connection", "Upgrade")
+		return nil, refuse(w, http.StatusUpgradeRequired, "not a websocket upgrade")
+	}
+
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, refuse(w, http.StatusUpgradeRequired, "unsupported Sec-WebSocket-Version")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return nil, refuse(w, http.StatusBadRequest, "invalid Sec-WebSocket-Key")
+	}
+
+	if cfg.checkOrigin != nil && !cfg.checkOrigin(r) {
+		return nil, refuse(w, http.StatusForbidden, "origin not allowed")
+	}
+
+	subprotocol := selectSubprotocol(r, cfg.subprotocols)
+
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		// HTTP/2 streams can not be hijacked, RFC 8441 is not supported
+		return nil, refuse(w, http.StatusInternalServerError, "connection can not be upgraded")
+	}
+
+	// the server's read and write timeouts are meant for requests
+	conn.SetDeadline(time.Time{})
+
+	var b strings.Builder
+	b.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	b.WriteString("Upgrade: websocket\r\nConnection: Upgrade\r\n")
+	b.WriteString("Sec-WebSocket-Accept: " + acceptKey(key) + "\r\n")
+
+	if subprotocol != "" {
+		b.WriteString("Sec-WebSocket-Protocol: " + subprotocol + "\r\n")
+	}
+
+	b.WriteString("\r\n")
+
+	if _, err := brw.WriteString(b.String()); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	// frames the client sent right after its request are in the reader
+	return newConn(conn, brw.Reader, false, subprotocol, cfg), nil
+}
+
+// refuse answers a bad handshake
+func refuse(w http.ResponseWriter, code int, msg string) error {
+	http.Error(w, msg, code)
+	return fmt.Errorf("%w: %s", ErrBadHandshake, msg)
+}
+
+// selectSubprotocol picks the first of ours the client offers
+func selectSubprotocol(r *http.Request, ours []string) string {
+	offered := headerTokens(r.Header, "Sec-WebSocket-Protocol")
+
+	for _, p := range ours {
+		for _, o := range offered {
+			if p == o {
+				return p
+			}
+		}
+	}
+
+	return ""
+}
+
+// sameOrigin allows requests without Origin, which do not come from a
+// browser, and those from the same host
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	return strings.EqualFold(u.Host, r.Host)
+}
+
+// headerTokens splits the comma separated values of a header
+func headerTokens(h http.Header, name string) []string {
+	var tokens []string
+
+	for _, v := range h.Values(name) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+
+	return tokens
+}
+
+// headerHasToken reports whether a header lists the token, ignoring case
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, t := range headerTokens(h, name) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,187 @@
+// Package websocket implements the WebSocket protocol, RFC 6455, on top of
+// net/http, without dependencies
+//
+// a server upgrades a request with Accept, a client connects with Dial,
+// both get a Conn that reads and writes whole messages, ping/pong, the
+// close handshake and the checks the RFC asks for are done by the Conn
+// extensions, like permessage-deflate, are not supported
+package websocket
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// DefaultReadLimit caps the size of a message read, larger ones close the
+// connection with StatusMessageTooBig
+const DefaultReadLimit = 1 << 20
+
+// closeTimeout bounds the close handshake, and the write of a close frame
+// when the connection fails
+const closeTimeout = 5 * time.Second
+
+// acceptGUID is appended to the client's key to compute the server's answer
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+var (
+	// ErrBadHandshake is returned by Accept and Dial when the request or
+	// the response is not a valid opening handshake
+	ErrBadHandshake = errors.New("websocket: bad handshake")
+	// ErrProtocol is wrapped by the errors of a peer breaking the protocol,
+	// the connection is closed with StatusProtocolError
+	ErrProtocol = errors.New("websocket: protocol error")
+	// ErrReadLimit is returned when a message is larger than the read limit
+	ErrReadLimit = errors.New("websocket: message exceeds the read limit")
+	// ErrClosed is returned when writing after the close frame was sent
+	ErrClosed = errors.New("websocket: use of closed connection")
+	// ErrKeepaliveTimeout closes a connection whose peer did not answer a
+	// keepalive ping in time
+	ErrKeepaliveTimeout = errors.New("websocket: keepalive timed out")
+)
+
+// MessageType is the type of a data message
+type MessageType int
+
+const (
+	TextMessage   MessageType = MessageType(opText)
+	BinaryMessage MessageType = MessageType(opBinary)
+)
+
+// StatusCode is the status of a close frame, RFC 6455 section 7.4
+type StatusCode int
+
+const (
+	StatusNormalClosure           StatusCode = 1000
+	StatusGoingAway               StatusCode = 1001
+	StatusProtocolError           StatusCode = 1002
+	StatusUnsupportedData         StatusCode = 1003
+	StatusNoStatusReceived        StatusCode = 1005 // never sent, a close frame without status
+	StatusAbnormalClosure         StatusCode = 1006 // never sent, a connection lost without close frame
+	StatusInvalidFramePayloadData StatusCode = 1007
+	StatusPolicyViolation         StatusCode = 1008
+	StatusMessageTooBig           StatusCode = 1009
+	StatusMandatoryExtension      StatusCode = 1010
+	StatusInternalError           StatusCode = 1011
+)
+
+// validReceived reports whether a peer may send the status
+func (s StatusCode) validReceived() bool {
+	switch {
+	case s >= 1000 && s <= 1003, s >= 1007 && s <= 1014:
+		return true
+	default:
+		// registered by libraries and frameworks, or private
+		return s >= 3000 && s <= 4999
+	}
+}
+
+// CloseError is the close frame the peer sent, reads fail with it once the
+// connection is closed
+type CloseError struct {
+	Code   StatusCode
+	Reason string
+}
+
+func (e *CloseError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("websocket: closed with status %d", e.Code)
+	}
+
+	return fmt.Sprintf("websocket: closed with status %d: %s", e.Code, e.Reason)
+}
+
+// Option configures Accept and Dial
+type Option func(*config)
+
+type config struct {
+	subprotocols []string
+	checkOrigin  func(r *http.Request) bool
+	readLimit    int64
+	pingInterval time.Duration
+	netDial      func(ctx context.Context, network, address string) (net.Conn, error)
+	tlsConfig    *tls.Config
+	header       http.Header
+}
+
+func newConfig(opts []Option) *config {
+	cfg := &config{
+		readLimit:   DefaultReadLimit,
+		checkOrigin: sameOrigin,
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return cfg
+}
+
+// WithSubprotocols sets the subprotocols, in order of preference, Accept
+// picks the first one the client offers, Dial offers them all
+func WithSubprotocols(protocols ...string) Option {
+	return func(c *config) {
+		c.subprotocols = protocols
+	}
+}
+
+// WithOriginCheck replaces the origin check of Accept, by default a
+// request with an Origin header is accepted only from the same host, as a
+// browser sends cookies along with cross-site websocket requests
+func WithOriginCheck(fn func(r *http.Request) bool) Option {
+	return func(c *config) {
+		c.checkOrigin = fn
+	}
+}
+
+// WithReadLimit caps the size of a message read, DefaultReadLimit by
+// default
+func WithReadLimit(n int64) Option {
+	return func(c *config) {
+		c.readLimit = n
+	}
+}
+
+// WithPingInterval pings the peer every d, a peer that does not answer
+// within d has the connection closed with ErrKeepaliveTimeout
+// pongs are read by Read, so the keepalive needs a reader
+func WithPingInterval(d time.Duration) Option {
+	return func(c *config) {
+		c.pingInterval = d
+	}
+}
+
+// WithNetDial replaces the dial of Dial, to connect through a proxy or to
+// an in-memory net.Pipe in tests
+func WithNetDial(fn func(ctx context.Context, network, address string) (net.Conn, error)) Option {
+	return func(c *config) {
+		c.netDial = fn
+	}
+}
+
+// WithTLSConfig sets the TLS configuration Dial uses for wss:// URLs
+func WithTLSConfig(cfg *tls.Config) Option {
+	return func(c *config) {
+		c.tlsConfig = cfg
+	}
+}
+
+// WithHeader adds headers to the opening request of Dial, like
+// Authorization or Origin
+func WithHeader(h http.Header) Option {
+	return func(c *config) {
+		c.header = h
+	}
+}
+
+// acceptKey is the Sec-WebSocket-Accept answering key
+func acceptKey(key string) string {
+	h := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h[:])
+}
@@ -0,0 +1,392 @@
+package websocket
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/synctest"
+	"time"
+)
+
+// pipeListener hands out the server ends of net.Pipe connections
+type pipeListener struct {
+	conns  chan net.Conn
+	closed chan struct{}
+}
+
+func newPipeListener() *pipeListener {
+	return &pipeListener{conns: make(chan net.Conn), closed: make(chan struct{})}
+}
+
+// dial is a WithNetDial for clients of the listener
+func (l *pipeListener) dial(ctx context.Context, network, address string) (net.Conn, error) {
+	srv, cli := net.Pipe()
+
+	select {
+	case l.conns <- srv:
+		return cli, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (l *pipeListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.conns:
+		return c, nil
+	case <-l.closed:
+		return nil, net.ErrClosed
+	}
+}
+
+func (l *pipeListener) Close() error {
+	close(l.closed)
+	return nil
+}
+
+func (l *pipeListener) Addr() net.Addr {
+	return pipeAddr{}
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// pipe returns a server and a client Conn over net.Pipe, without handshake
+func pipe(opts ...Option) (srv, cli *Conn) {
+	s, c := net.Pipe()
+	cfg := newConfig(opts)
+
+	return newConn(s, bufio.NewReader(s), false, "", cfg), newConn(c, bufio.NewReader(c), true, "", cfg)
+}
+
+func TestDialAccept(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		ln := newPipeListener()
+
+		serverErr := make(chan error, 1)
+
+		srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			c, err := Accept(w, r, WithSubprotocols("v2.chat", "chat"))
+			if err != nil {
+				serverErr <- err
+				return
+			}
+
+			// echo until the client closes
+			for {
+				typ, p, err := c.Read(context.Background())
+				if err != nil {
+					serverErr <- err
+					return
+				}
+
+				if err := c.Write(context.Background(), typ, p); err != nil {
+					serverErr <- err
+					return
+				}
+			}
+		})}
+
+		go srv.Serve(ln)
+		defer srv.Close()
+
+		ctx := context.Background()
+
+		c, res, err := Dial(ctx, "ws://example.test/chat", WithNetDial(ln.dial), WithSubprotocols("chat"))
+		if err != nil {
+			t.Fatalf("Dial: %v", err)
+		}
+
+		if res.StatusCode != http.StatusSwitchingProtocols || c.Subprotocol() != "chat" {
+			t.Fatalf("handshake: status %d, subprotocol %q", res.StatusCode, c.Subprotocol())
+		}
+
+		if err := c.Write(ctx, TextMessage, []byte("hello")); err != nil {
+			t.Fatalf("Write: %v", err)
+		}
+
+		if typ, p, err := c.Read(ctx); err != nil || typ != TextMessage || string(p) != "hello" {
+			t.Fatalf("Read = %v %q %v; want text hello", typ, p, err)
+		}
+
+		// a fragmented message comes back whole
+		w, err := c.Writer(ctx, BinaryMessage)
+		if err != nil {
+			t.Fatalf("Writer: %v", err)
+		}
+
+		large := bytes.Repeat([]byte{0, 1, 2, 3, 4}, 2000)
+		w.Write(large[:4000])
+		w.Write(large[4000:])
+
+		if err := w.Close(); err != nil {
+			t.Fatalf("Writer Close: %v", err)
+		}
+
+		if typ, p, err := c.Read(ctx); err != nil || typ != BinaryMessage || !bytes.Equal(p, large) {
+			t.Fatalf("Read = %v %d bytes %v; want the binary message of %d bytes", typ, len(p), err, len(large))
+		}
+
+		if err := c.Close(StatusNormalClosure, "bye"); err != nil {
+			t.Fatalf("Close: %v", err)
+		}
+
+		var ce *CloseError
+		if err := <-serverErr; !errors.As(err, &ce) || ce.Code != StatusNormalClosure || ce.Reason != "bye" {
+			t.Errorf("server Read error = %v; want the close frame with 1000 bye", err)
+		}
+
+		if err := c.Write(ctx, TextMessage, []byte("late")); err == nil {
+			t.Errorf("Write after Close succeeded")
+		}
+	})
+}
+
+func TestAcceptRefused(t *testing.T) {
+	upgrade := func(r *http.Request) *http.Request {
+		r.Header.Set("Connection", "keep-alive, Upgrade")
+		r.Header.Set("Upgrade", "websocket")
+		r.Header.Set("Sec-WebSocket-Version", "13")
+		r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+		return r
+	}
+
+	tests := []struct {
+		name string
+		req  *http.Request
+		code int
+	}{
+		{"not an upgrade", httptest.NewRequest(http.MethodGet, "/", nil), http.StatusUpgradeRequired},
+		{"POST", upgrade(httptest.NewRequest(http.MethodPost, "/", nil)), http.StatusMethodNotAllowed},
+		{"version", func() *http.Request {
+			r := upgrade(httptest.NewRequest(http.MethodGet, "/", nil))
+			r.Header.Set("Sec-WebSocket-Version", "8")
+			return r
+		}(), http.StatusUpgradeRequired},
+		{"short key", func() *http.Request {
+			r := upgrade(httptest.NewRequest(http.MethodGet, "/", nil))
+			r.Header.Set("Sec-WebSocket-Key", "c2hvcnQ=")
+			return r
+		}(), http.StatusBadRequest},
+		{"cross origin", func() *http.Request {
+			r := upgrade(httptest.NewRequest(http.MethodGet, "http://example.test/", nil))
+			r.Header.Set("Origin", "https://evil.test")
+			return r
+		}(), http.StatusForbidden},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+
+			if _, err := Accept(rec, tc.req); !errors.Is(err, ErrBadHandshake) {
+				t.Errorf("Accept error = %v; want ErrBadHandshake", err)
+			}
+
+			if rec.Code != tc.code {
+				t.Errorf("status = %d; want %d", rec.Code, tc.code)
+			}
+		})
+	}
+}
+
+func TestAcceptKey(t *testing.T) {
+	// the example of RFC 6455 section 1.3
+	if got := acceptKey("dGhlIHNhbXBsZSBub25jZQ=="); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Errorf("acceptKey = %q", got)
+	}
+}
+
+// rawFrame encodes a frame as a client would, masked unless told not to
+func rawFrame(fin bool, rsv byte, op opcode, masked bool, payload []byte) []byte {
+	var buf bytes.Buffer
+	bw := bufio.NewWriter(&buf)
+
+	h := header{fin: fin, rsv: rsv, op: op, masked: masked, mask: [4]byte{1, 2, 3, 4}, length: int64(len(payload))}
+	writeHeader(bw, h)
+
+	p := bytes.Clone(payload)
+	if masked {
+		maskBytes(h.mask, 0, p)
+	}
+
+	bw.Write(p)
+	bw.Flush()
+
+	return buf.Bytes()
+}
+
+func TestProtocolViolations(t *testing.T) {
+	closeFrame := func(code uint16, reason string) []byte {
+		p := binary.BigEndian.AppendUint16(nil, code)
+		return append(p, reason...)
+	}
+
+	tests := []struct {
+		name   string
+		frames [][]byte
+		status StatusCode
+		err    error
+	}{
+		{"unmasked", [][]byte{rawFrame(true, 0, opText, false, []byte("hi"))}, StatusProtocolError, ErrProtocol},
+		{"reserved bits", [][]byte{rawFrame(true, 0x40, opText, true, []byte("hi"))}, StatusProtocolError, ErrProtocol},
+		{"unknown opcode", [][]byte{rawFrame(true, 0, 0x3, true, nil)}, StatusProtocolError, ErrProtocol},
+		{"fragmented ping", [][]byte{rawFrame(false, 0, opPing, true, nil)}, StatusProtocolError, ErrProtocol},
+		{"continuation first", [][]byte{rawFrame(true, 0, opContinuation, true, []byte("hi"))}, StatusProtocolError, ErrProtocol},
+		{"interleaved messages", [][]byte{
+			rawFrame(false, 0, opText, true, []byte("a")),
+			rawFrame(true, 0, opText, true, []byte("b")),
+		}, StatusProtocolError, ErrProtocol},
+		{"invalid UTF-8", [][]byte{rawFrame(true, 0, opText, true, []byte{0xff, 0xfe})}, StatusInvalidFramePayloadData, ErrProtocol},
+		{"reserved close status", [][]byte{rawFrame(true, 0, opClose, true, closeFrame(1005, ""))}, StatusProtocolError, ErrProtocol},
+		{"over the read limit", [][]byte{
+			rawFrame(false, 0, opBinary, true, make([]byte, 60)),
+			rawFrame(true, 0, opContinuation, true, make([]byte, 60)),
+		}, StatusMessageTooBig, ErrReadLimit},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			synctest.Test(t, func(t *testing.T) {
+				s, raw := net.Pipe()
+				defer raw.Close()
+
+				c := newConn(s, bufio.NewReader(s), false, "", newConfig([]Option{WithReadLimit(100)}))
+
+				readErr := make(chan error, 1)
+				go func() {
+					_, _, err := c.Read(context.Background())
+					readErr <- err
+				}()
+
+				for _, f := range tc.frames {
+					raw.Write(f)
+				}
+
+				br := bufio.NewReader(raw)
+
+				h, err := readHeader(br)
+				if err != nil || h.op != opClose {
+					t.Fatalf("got %+v, %v; want a close frame", h, err)
+				}
+
+				p := make([]byte, h.length)
+				io.ReadFull(br, p)
+
+				if got := StatusCode(binary.BigEndian.Uint16(p)); got != tc.status {
+					t.Errorf("close status = %d; want %d", got, tc.status)
+				}
+
+				if err := <-readErr; !errors.Is(err, tc.err) {
+					t.Errorf("Read error = %v; want %v", err, tc.err)
+				}
+			})
+		})
+	}
+}
+
+func TestPingAnsweredDuringRead(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		srv, cli := pipe()
+
+		go srv.Read(context.Background())
+		go cli.Read(context.Background())
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+
+		if err := cli.Ping(ctx); err != nil {
+			t.Errorf("Ping: %v", err)
+		}
+
+		if err := srv.Ping(ctx); err != nil {
+			t.Errorf("Ping: %v", err)
+		}
+
+		cli.Close(StatusGoingAway, "")
+		synctest.Wait()
+	})
+}
+
+func TestKeepalive(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		srv, cli := pipe(WithPingInterval(10 * time.Second))
+
+		srvErr := make(chan error, 1)
+		go func() {
+			_, _, err := srv.Read(context.Background())
+			srvErr <- err
+		}()
+
+		cliErr := make(chan error, 1)
+		go func() {
+			_, _, err := cli.Read(context.Background())
+			cliErr <- err
+		}()
+
+		// both answer, the connection stays up
+		time.Sleep(time.Minute)
+		synctest.Wait()
+
+		select {
+		case err := <-srvErr:
+			t.Fatalf("server Read returned %v while pongs came back", err)
+		default:
+		}
+
+		cli.Close(StatusNormalClosure, "")
+
+		<-srvErr
+		<-cliErr
+	})
+
+	synctest.Test(t, func(t *testing.T) {
+		s, cli := net.Pipe()
+		defer cli.Close()
+
+		srv := newConn(s, bufio.NewReader(s), false, "", newConfig([]Option{WithPingInterval(10 * time.Second)}))
+
+		// the client never reads, the server's ping goes unanswered
+		_, _, err := srv.Read(context.Background())
+		if !errors.Is(err, ErrKeepaliveTimeout) {
+			t.Errorf("Read error = %v; want ErrKeepaliveTimeout", err)
+		}
+	})
+}
+
+func TestReadContext(t *testing.T) {
+	synctest.Test(t, func(t *testing.T) {
+		srv, cli := pipe()
+		defer cli.conn.Close()
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		start := time.Now()
+
+		_, _, err := srv.Read(ctx)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("Read error = %v; want DeadlineExceeded", err)
+		}
+
+		if d := time.Since(start); d != 5*time.Second {
+			t.Errorf("Read returned after %v; want 5s", d)
+		}
+
+		// the stream position is lost, the connection is closed
+		if err := srv.Write(context.Background(), TextMessage, []byte("x")); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Write after a canceled Read = %v; want DeadlineExceeded", err)
+		}
+	})
+}
@@ -0,0 +1,398 @@
+package optionex
+
+import (
+	"container/list"
+	"errors"
+	"expvar"
+	"fmt"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Priority orders requests for load shedding
+type Priority int
+
+const (
+	// PriorityLow requests are shed first, they never wait in the queue
+	PriorityLow Priority = iota
+	// PriorityNormal requests wait in the queue when the limit is reached
+	PriorityNormal
+	// PriorityCritical requests are never shed or queued, like health checks
+	PriorityCritical
+)
+
+// defaults of the adaptive limiter
+const (
+	DefaultAdaptiveInitialLimit = 20
+	DefaultAdaptiveMinLimit     = 1
+	DefaultAdaptiveMaxLimit     = 1000
+	DefaultAdaptiveSmoothing    = 0.2
+	DefaultAdaptiveTarget       = 5 * time.Millisecond
+	DefaultAdaptiveInterval     = 100 * time.Millisecond
+)
+
+// AdaptiveConfig configures an AdaptiveLimiter
+type AdaptiveConfig struct {
+	InitialLimit int     // in flight limit at start
+	MinLimit     int     // the limit never drops below it
+	MaxLimit     int     // the limit never grows above it
+	Smoothing    float64 // how fast the limit follows the gradient, in (0, 1]
+	MaxQueue     int     // requests waiting for a slot, defaults to the max limit
+
+	// the queue follows CoDel, while the queueing delay stays above Target
+	// for a whole Interval the queue is overloaded and requests that wait
+	// longer than Target are shed, otherwise they wait up to Interval
+	Target   time.Duration
+	Interval time.Duration
+
+	// Priority classifies requests, by default the health endpoints are
+	// critical and everything else is normal
+	Priority func(*http.Request) Priority
+
+	// MetricsName publishes the limiter stats with expvar under this name,
+	// empty disables publishing, a name already published is not replaced,
+	// WithAdaptiveConcurrency fails the server with ErrMetricsNameInUse
+	MetricsName string
+}
+
+// ErrMetricsNameInUse is returned when AdaptiveConfig.MetricsName is
+// published already, by another limiter or anything else
+var ErrMetricsNameInUse = errors.New("optionex: expvar name already published")
+
+// AdaptiveStats is a snapshot of an AdaptiveLimiter
+type AdaptiveStats struct {
+	Limit      int           `json:"limit"`
+	InFlight   int           `json:"in_flight"`          // critical requests left out
+	Critical   int           `json:"critical_in_flight"` // outside of the limit
+	Queued     int           `json:"queued"`
+	Shed       uint64        `json:"shed"`
+	ShortRTT   time.Duration `json:"short_rtt_ns"`
+	LongRTT    time.Duration `json:"long_rtt_ns"`
+	Overloaded bool          `json:"overloaded"`
+}
+
+// AdaptiveLimiter limits the requests in flight with a limit that adapts
+// to the latency, following the gradient algorithm
+// the limit grows while the short term latency stays close to the long
+// term one, and shrinks in proportion once the short term latency rises,
+// which is the sign that requests queue up somewhere
+type AdaptiveLimiter struct {
+	cfg AdaptiveConfig
+
+	mu       sync.Mutex
+	limit    float64
+	inFlight int
+	critical int        // critical requests in flight, not in inFlight
+	waiters  *list.List // of *waiter, front is the oldest
+	shed     uint64
+
+	// metricsErr is why the stats were not published, for the server
+	metricsErr error
+
+	// latency estimates, exponentially weighted moving averages
+	shortRTT float64
+	longRTT  float64
+
+	// CoDel state
+	firstAboveTarget time.Time
+	overloaded       bool
+}
+
+type waiter struct {
+	ready    chan struct{}
+	admitted bool
+}
+
+// NewAdaptiveLimiter creates a limiter, use it with WithAdaptiveConcurrency
+// or wrap handlers with its Middleware
+func NewAdaptiveLimiter(cfg AdaptiveConfig) *AdaptiveLimiter {
+	if cfg.MinLimit <= 0 {
+		cfg.MinLimit = DefaultAdaptiveMinLimit
+	}
+
+	if cfg.MaxLimit <= 0 {
+		cfg.MaxLimit = DefaultAdaptiveMaxLimit
+	}
+
+	if cfg.InitialLimit <= 0 {
+		cfg.InitialLimit = DefaultAdaptiveInitialLimit
+	}
+
+	cfg.InitialLimit = min(max(cfg.InitialLimit, cfg.MinLimit), cfg.MaxLimit)
+
+	if cfg.Smoothing <= 0 || cfg.Smoothing > 1 {
+		cfg.Smoothing = DefaultAdaptiveSmoothing
+	}
+
+	if cfg.MaxQueue <= 0 {
+		cfg.MaxQueue = cfg.MaxLimit
+	}
+
+	if cfg.Target <= 0 {
+		cfg.Target = DefaultAdaptiveTarget
+	}
+
+	if cfg.Interval <= 0 {
+		cfg.Interval = DefaultAdaptiveInterval
+	}
+
+	if cfg.Priority == nil {
+		cfg.Priority = defaultPriority
+	}
+
+	l := &AdaptiveLimiter{
+		cfg:     cfg,
+		limit:   float64(cfg.InitialLimit),
+		waiters: list.New(),
+	}
+
+	if cfg.MetricsName != "" {
+		l.metricsErr = publishMetrics(cfg.MetricsName, expvar.Func(func() any { return l.Stats() }))
+	}
+
+	return l
+}
+
+// metricsMu makes the check and the publish of publishMetrics one step
+var metricsMu sync.Mutex
+
+// publishMetrics publishes v under name unless the name is taken, expvar
+// panics on a name published twice
+func publishMetrics(name string, v expvar.Var) error {
+	metricsMu.Lock()
+	defer metricsMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("%w: %q", ErrMetricsNameInUse, name)
+	}
+
+	expvar.Publish(name, v)
+
+	return nil
+}
+
+// WithAdaptiveConcurrency adds the middleware of the limiter l, the server
+// fails to start if the stats of l could not be published
+func WithAdaptiveConcurrency(l *AdaptiveLimiter) Option {
+	return func(srv *Server) {
+		if l.metricsErr != nil {
+			srv.initErr = errors.Join(srv.initErr, l.metricsErr)
+		}
+
+		WithMiddleware(l.Middleware())(srv)
+	}
+}
+
+// defaultPriority makes the health endpoints critical
+func defaultPriority(r *http.Request) Priority {
+	switch r.URL.Path {
+	case LivezPath, ReadyzPath, HealthzPath:
+		return PriorityCritical
+	}
+
+	return PriorityNormal
+}
+
+// Limit returns the current in flight limit
+func (l *AdaptiveLimiter) Limit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return int(l.limit)
+}
+
+// Stats returns a snapshot of the limiter
+func (l *AdaptiveLimiter) Stats() AdaptiveStats {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return AdaptiveStats{
+		Limit:      int(l.limit),
+		InFlight:   l.inFlight,
+		Critical:   l.critical,
+		Queued:     l.waiters.Len(),
+		Shed:       l.shed,
+		ShortRTT:   time.Duration(l.shortRTT),
+		LongRTT:    time.Duration(l.longRTT),
+		Overloaded: l.overloaded,
+	}
+}
+
+// Middleware sheds requests above the adaptive limit with 503
+func (l *AdaptiveLimiter) Middleware() Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			prio := l.cfg.Priority(r)
+
+			if !l.acquire(r, prio) {
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+				return
+			}
+
+			start := time.Now()
+
+			defer func() {
+				l.release(time.Since(start), prio)
+			}()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// acquire admits the request, it reports false if the request is shed
+func (l *AdaptiveLimiter) acquire(r *http.Request, prio Priority) bool {
+	l.mu.Lock()
+
+	// critical requests bypass the limit, they are kept out of inFlight
+	// so a burst of health checks does not shed the normal traffic
+	if prio == PriorityCritical {
+		l.critical++
+		l.mu.Unlock()
+		return true
+	}
+
+	if l.inFlight < int(l.limit) {
+		l.inFlight++
+		// admitted without waiting, the queue has drained
+		l.observeQueueDelayLocked(0)
+		l.mu.Unlock()
+		return true
+	}
+
+	if prio == PriorityLow || l.waiters.Len() >= l.cfg.MaxQueue {
+		l.shed++
+		l.mu.Unlock()
+		return false
+	}
+
+	w := &waiter{ready: make(chan struct{})}
+	e := l.waiters.PushBack(w)
+
+	timeout := l.cfg.Interval
+	if l.overloaded {
+		timeout = l.cfg.Target
+	}
+
+	l.mu.Unlock()
+
+	enqueued := time.Now()
+
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+
+	select {
+	case <-w.ready:
+		l.observeQueueDelay(time.Since(enqueued))
+		return true
+	case <-t.C:
+	case <-r.Context().Done():
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// the slot may have been handed over while the timer fired
+	if w.admitted {
+		l.observeQueueDelayLocked(time.Since(enqueued))
+		return true
+	}
+
+	l.waiters.Remove(e)
+	l.shed++
+	l.observeQueueDelayLocked(time.Since(enqueued))
+
+	return false
+}
+
+// release frees the slot, feeds the latency sample to the limit and hands
+// free slots to the oldest waiters
+func (l *AdaptiveLimiter) release(rtt time.Duration, prio Priority) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// a critical request took no slot, its latency says nothing about
+	// the limit
+	if prio == PriorityCritical {
+		l.critical--
+		return
+	}
+
+	l.update(rtt)
+
+	l.inFlight--
+
+	for l.waiters.Len() > 0 && l.inFlight < int(l.limit) {
+		w := l.waiters.Remove(l.waiters.Front()).(*waiter)
+		w.admitted = true
+		l.inFlight++
+		close(w.ready)
+	}
+}
+
+// update applies one latency sample to the limit, l.mu must be held
+func (l *AdaptiveLimiter) update(rtt time.Duration) {
+	sample := float64(rtt)
+	if sample <= 0 {
+		sample = 1
+	}
+
+	if l.longRTT == 0 {
+		l.shortRTT, l.longRTT = sample, sample
+	}
+
+	// the short window follows the last ~10 samples, the long one ~600
+	l.shortRTT += (sample - l.shortRTT) * 0.1
+	l.longRTT += (sample - l.longRTT) / 600
+
+	// after a long overload the long term estimate is stale and would
+	// keep the limit down, let it recover faster
+	if l.longRTT/l.shortRTT > 2 {
+		l.longRTT *= 0.95
+	}
+
+	gradient := math.Max(0.5, math.Min(1, l.longRTT/l.shortRTT))
+	queueSize := math.Sqrt(l.limit)
+
+	newLimit := l.limit*gradient + queueSize
+	newLimit = l.limit*(1-l.cfg.Smoothing) + newLimit*l.cfg.Smoothing
+
+	// an application that does not use half of its limit gives no signal
+	// for growing it
+	if newLimit > l.limit && float64(l.inFlight) < l.limit/2 {
+		return
+	}
+
+	l.limit = math.Max(float64(l.cfg.MinLimit), math.Min(float64(l.cfg.MaxLimit), newLimit))
+}
+
+func (l *AdaptiveLimiter) observeQueueDelay(d time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.observeQueueDelayLocked(d)
+}
+
+// observeQueueDelayLocked tracks the CoDel state, the queue is overloaded
+// once the delay stayed above the target for a whole interval
+func (l *AdaptiveLimiter) observeQueueDelayLocked(d time.Duration) {
+	now := time.Now()
+
+	if d < l.cfg.Target {
+		l.firstAboveTarget = time.Time{}
+		l.overloaded = false
+		return
+	}
+
+	if l.firstAboveTarget.IsZero() {
+		l.firstAboveTarget = now
+		return
+	}
+
+	if now.Sub(l.firstAboveTarget) >= l.cfg.Interval {
+		l.overloaded = true
+	}
+}
@@ -0,0 +1,159 @@
+package optionex
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimitFollowsLatency(t *testing.T) {
+	l := NewAdaptiveLimiter(AdaptiveConfig{InitialLimit: 10, MaxLimit: 100})
+
+	// keep the limiter busy, an idle one does not grow its limit
+	l.inFlight = 10
+
+	for range 200 {
+		l.update(10 * time.Millisecond)
+	}
+
+	grown := l.Limit()
+	if grown <= 10 {
+		t.Fatalf("limit = %d after stable latency; want it above the initial 10", grown)
+	}
+
+	for range 50 {
+		l.update(100 * time.Millisecond)
+	}
+
+	if shrunk := l.Limit(); shrunk >= grown {
+		t.Errorf("limit = %d after the latency rose; want it below %d", shrunk, grown)
+	}
+}
+
+func TestAdaptiveLimiterPriorities(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	l := NewAdaptiveLimiter(AdaptiveConfig{
+		InitialLimit: 1,
+		MaxLimit:     1,
+		Priority: func(r *http.Request) Priority {
+			if r.URL.Path == "/batch" {
+				return PriorityLow
+			}
+
+			return defaultPriority(r)
+		},
+	})
+
+	h := l.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			close(started)
+			<-release
+		}
+	}))
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/slow", nil))
+	}()
+
+	<-started
+
+	tests := []struct {
+		path string
+		code int
+	}{
+		{"/batch", http.StatusServiceUnavailable},
+		{HealthzPath, http.StatusOK},
+	}
+
+	for _, tc := range tests {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+		if rec.Code != tc.code {
+			t.Errorf("%s status = %d; want %d", tc.path, rec.Code, tc.code)
+		}
+	}
+
+	close(release)
+	wg.Wait()
+
+	if s := l.Stats(); s.Shed != 1 || s.InFlight != 0 {
+		t.Errorf("stats = %+v; want 1 shed and nothing in flight", s)
+	}
+}
+
+func TestAdaptiveLimiterCriticalOutsideLimit(t *testing.T) {
+	release := make(chan struct{})
+	started := make(chan struct{})
+
+	l := NewAdaptiveLimiter(AdaptiveConfig{
+		InitialLimit: 1,
+		MaxLimit:     1,
+		Priority: func(r *http.Request) Priority {
+			if r.URL.Path == "/batch" {
+				return PriorityLow
+			}
+
+			return defaultPriority(r)
+		},
+	})
+
+	h := l.Middleware()(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == HealthzPath {
+			started <- struct{}{}
+			<-release
+		}
+	}))
+
+	// health checks hold no slot, even once they fill the limit
+	var wg sync.WaitGroup
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, HealthzPath, nil))
+		}()
+		<-started
+	}
+
+	if s := l.Stats(); s.InFlight != 0 || s.Critical != 2 {
+		t.Errorf("stats = %+v; want 2 critical and nothing in flight", s)
+	}
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/batch", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("/batch status = %d during health checks; want 200", rec.Code)
+	}
+
+	close(release)
+	wg.Wait()
+
+	if s := l.Stats(); s.Critical != 0 || s.Shed != 0 {
+		t.Errorf("stats = %+v; want nothing in flight and none shed", s)
+	}
+}
+
+func TestAdaptiveLimiterMetricsNameInUse(t *testing.T) {
+	cfg := AdaptiveConfig{MetricsName: "optionex_test_adaptive"}
+
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	if srv := NewServer("127.0.0.1:0", h, WithAdaptiveConcurrency(NewAdaptiveLimiter(cfg))); srv.initErr != nil {
+		t.Fatalf("first server: %v", srv.initErr)
+	}
+
+	srv := NewServer("127.0.0.1:0", h, WithAdaptiveConcurrency(NewAdaptiveLimiter(cfg)))
+	if !errors.Is(srv.initErr, ErrMetricsNameInUse) {
+		t.Errorf("second server error = %v; want ErrMetricsNameInUse", srv.initErr)
+	}
+}
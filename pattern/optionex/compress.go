@@ -0,0 +1,370 @@
+package optionex
+
+import (
+	"bufio"
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultCompressMinSize is the smallest response compressed, below it the
+// encoding costs more than it saves
+const DefaultCompressMinSize = 1024
+
+// DefaultCompressContentTypes are the types compressed by default, a type
+// ending in "/" matches every subtype
+var DefaultCompressContentTypes = []string{
+	"text/",
+	"application/json",
+	"application/javascript",
+	"application/xml",
+	"application/problem+json",
+	"image/svg+xml",
+}
+
+// CompressConfig configures the Compress middleware
+type CompressConfig struct {
+	// Level of gzip and deflate, gzip.DefaultCompression when 0
+	Level int
+	// MinSize is the smallest body compressed, DefaultCompressMinSize when 0
+	MinSize int
+	// ContentTypes are the compressed types, DefaultCompressContentTypes
+	// when empty, text/event-stream is never compressed
+	ContentTypes []string
+}
+
+// WithCompression adds the Compress middleware
+func WithCompression(cfg CompressConfig) Option {
+	return WithMiddleware(Compress(cfg))
+}
+
+// Compress compresses responses with gzip or deflate, as the request's
+// Accept-Encoding allows, gzip is preferred when both are
+// a response is passed through as is if it is smaller than MinSize, its
+// type is not in ContentTypes, it already has a Content-Encoding, it is
+// flushed before MinSize bytes were written, like a stream, the handler
+// hijacks the connection, or the request asks for a range
+// every response gets "Vary: Accept-Encoding", since its encoding could
+// have depended on it
+func Compress(cfg CompressConfig) Middleware {
+	if cfg.Level == 0 {
+		cfg.Level = gzip.DefaultCompression
+	}
+
+	if cfg.MinSize <= 0 {
+		cfg.MinSize = DefaultCompressMinSize
+	}
+
+	if len(cfg.ContentTypes) == 0 {
+		cfg.ContentTypes = DefaultCompressContentTypes
+	}
+
+	pools := map[string]*sync.Pool{
+		"gzip": {New: func() any {
+			zw, _ := gzip.NewWriterLevel(io.Discard, cfg.Level)
+			return zw
+		}},
+		"deflate": {New: func() any {
+			// HTTP deflate is the zlib format, not raw deflate
+			zw, _ := zlib.NewWriterLevel(io.Discard, cfg.Level)
+			return zw
+		}},
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+
+			encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+
+			// a range of the encoded body is not a range of the file
+			if encoding == "" || r.Header.Get("Range") != "" || r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{
+				ResponseWriter: w,
+				cfg:            &cfg,
+				encoding:       encoding,
+				pool:           pools[encoding],
+			}
+
+			next.ServeHTTP(cw, r)
+
+			// not deferred, after a panic the response is left to Recovery
+			cw.close()
+		})
+	}
+}
+
+// negotiateEncoding picks gzip or deflate from Accept-Encoding, following
+// the q values, or "" for no encoding
+func negotiateEncoding(accept string) string {
+	q := map[string]float64{}
+
+	for _, part := range strings.Split(accept, ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.ToLower(strings.TrimSpace(coding))
+
+		if coding == "" {
+			continue
+		}
+
+		weight := 1.0
+
+		for _, p := range strings.Split(params, ";") {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil {
+					weight = f
+				}
+			}
+		}
+
+		q[coding] = weight
+	}
+
+	best, bestQ := "", 0.0
+
+	for _, coding := range []string{"gzip", "deflate"} {
+		weight, ok := q[coding]
+		if !ok {
+			// * covers the codings not listed
+			weight, ok = q["*"]
+		}
+
+		if ok && weight > bestQ {
+			best, bestQ = coding, weight
+		}
+	}
+
+	return best
+}
+
+// compressWriter buffers the start of the response until it can decide
+// whether to compress it
+type compressWriter struct {
+	http.ResponseWriter
+	cfg      *CompressConfig
+	encoding string
+	pool     *sync.Pool
+
+	status  int
+	buf     []byte
+	decided bool
+	zw      compressor
+}
+
+// compressor is implemented by the gzip and zlib writers
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+func (cw *compressWriter) WriteHeader(code int) {
+	// informational responses go out right away
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	if cw.status != 0 {
+		return
+	}
+
+	cw.status = code
+
+	// responses without a body, and ones the handler already encoded or
+	// whose length does not reach the threshold, are not touched
+	if !bodyAllowed(code) || cw.Header().Get("Content-Encoding") != "" {
+		cw.passthrough()
+		return
+	}
+
+	if cl := cw.Header().Get("Content-Length"); cl != "" {
+		if n, err := strconv.Atoi(cl); err == nil && n < cw.cfg.MinSize {
+			cw.passthrough()
+		}
+	}
+}
+
+func (cw *compressWriter) Write(b []byte) (int, error) {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.decided {
+		if cw.zw != nil {
+			return cw.zw.Write(b)
+		}
+
+		return cw.ResponseWriter.Write(b)
+	}
+
+	cw.buf = append(cw.buf, b...)
+
+	if len(cw.buf) >= cw.cfg.MinSize {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// decide compresses the response if its type allows, and writes the
+// buffered start of the body
+func (cw *compressWriter) decide() error {
+	h := cw.Header()
+
+	// the type must be set before the body is encoded, sniffing the
+	// encoded bytes would find gzip
+	ct := h.Get("Content-Type")
+	if ct == "" {
+		ct = http.DetectContentType(cw.buf)
+		h.Set("Content-Type", ct)
+	}
+
+	if !cw.compressible(ct) {
+		return cw.flushPassthrough()
+	}
+
+	h.Set("Content-Encoding", cw.encoding)
+	h.Del("Content-Length")
+	h.Del("Accept-Ranges")
+
+	// the encoded representation is not byte for byte the same
+	if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set("ETag", "W/"+etag)
+	}
+
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+
+	cw.zw = cw.pool.Get().(compressor)
+	cw.zw.Reset(cw.ResponseWriter)
+
+	buf := cw.buf
+	cw.buf = nil
+
+	_, err := cw.zw.Write(buf)
+
+	return err
+}
+
+func (cw *compressWriter) compressible(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil || mt == "text/event-stream" {
+		return false
+	}
+
+	for _, t := range cw.cfg.ContentTypes {
+		if mt == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mt, t) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// passthrough sends the response as is from here on
+func (cw *compressWriter) passthrough() {
+	cw.decided = true
+	cw.ResponseWriter.WriteHeader(cw.status)
+}
+
+// flushPassthrough passes the response through and writes the buffer
+func (cw *compressWriter) flushPassthrough() error {
+	// sniff while the header can still change, as net/http would
+	if len(cw.buf) > 0 && cw.Header().Get("Content-Type") == "" {
+		cw.Header().Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	cw.passthrough()
+
+	buf := cw.buf
+	cw.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+
+	_, err := cw.ResponseWriter.Write(buf)
+
+	return err
+}
+
+// Flush sends what was written so far, a flush before the decision means
+// the handler streams, the response is then passed through
+func (cw *compressWriter) Flush() {
+	if cw.status == 0 {
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if !cw.decided {
+		cw.flushPassthrough()
+	}
+
+	if cw.zw != nil {
+		cw.zw.Flush()
+	}
+
+	if f, ok := cw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (cw *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := cw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("optionex: underlying ResponseWriter does not implement http.Hijacker")
+	}
+
+	// nothing is written through the writer anymore
+	cw.decided = true
+
+	return h.Hijack()
+}
+
+// Unwrap is used by http.ResponseController
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
+
+// close finishes the response once the handler returned
+func (cw *compressWriter) close() {
+	if !cw.decided {
+		// the handler wrote nothing, or less than the threshold
+		if cw.status == 0 && len(cw.buf) == 0 {
+			return
+		}
+
+		if cw.status == 0 {
+			cw.status = http.StatusOK
+		}
+
+		cw.flushPassthrough()
+
+		return
+	}
+
+	if cw.zw != nil {
+		cw.zw.Close()
+		cw.pool.Put(cw.zw)
+		cw.zw = nil
+	}
+}
+
+// bodyAllowed reports whether a response with the status has a body
+func bodyAllowed(status int) bool {
+	return status >= 200 && status != http.StatusNoContent && status != http.StatusNotModified
+}
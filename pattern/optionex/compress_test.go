@@ -0,0 +1,116 @@
+package optionex_test
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tanveerprottoy/advanced-go/pattern/optionex"
+)
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("compressible text ", 100)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/large", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"v1"`)
+		io.WriteString(w, large)
+	})
+	mux.HandleFunc("/small", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "tiny")
+	})
+	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		io.WriteString(w, large)
+	})
+	mux.HandleFunc("/encoded", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Encoding", "br")
+		io.WriteString(w, large)
+	})
+	mux.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		io.WriteString(w, "event 1\n")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, large)
+	})
+
+	h := optionex.Compress(optionex.CompressConfig{MinSize: 256})(mux)
+
+	tests := []struct {
+		name     string
+		path     string
+		accept   string
+		encoding string
+		etag     string
+	}{
+		{name: "gzip", path: "/large", accept: "gzip, deflate", encoding: "gzip", etag: `W/"v1"`},
+		{name: "deflate preferred by q", path: "/large", accept: "gzip;q=0.5, deflate", encoding: "deflate"},
+		{name: "gzip refused", path: "/large", accept: "gzip;q=0", etag: `"v1"`},
+		{name: "wildcard", path: "/large", accept: "br, *", encoding: "gzip"},
+		{name: "no Accept-Encoding", path: "/large"},
+		{name: "below the threshold", path: "/small", accept: "gzip"},
+		{name: "type not allowed", path: "/image", accept: "gzip"},
+		{name: "already encoded", path: "/encoded", accept: "gzip", encoding: "br"},
+		{name: "streaming", path: "/stream", accept: "gzip"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, tc.path, nil)
+			if tc.accept != "" {
+				req.Header.Set("Accept-Encoding", tc.accept)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tc.encoding {
+				t.Fatalf("Content-Encoding = %q; want %q", got, tc.encoding)
+			}
+
+			if got := rec.Header().Get("Vary"); got != "Accept-Encoding" {
+				t.Errorf("Vary = %q; want Accept-Encoding", got)
+			}
+
+			if tc.etag != "" && rec.Header().Get("ETag") != tc.etag {
+				t.Errorf("ETag = %q; want %q", rec.Header().Get("ETag"), tc.etag)
+			}
+
+			var body io.Reader = rec.Body
+
+			switch tc.encoding {
+			case "gzip":
+				body, _ = gzip.NewReader(rec.Body)
+			case "deflate":
+				body, _ = zlib.NewReader(rec.Body)
+			case "br":
+				return
+			}
+
+			b, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("read body: %v", err)
+			}
+
+			want := large
+			switch tc.path {
+			case "/small":
+				want = "tiny"
+			case "/stream":
+				want = "event 1\n" + large
+			}
+
+			if string(b) != want {
+				t.Errorf("body = %q...; want %q...", b[:min(len(b), 20)], want[:20])
+			}
+
+			if tc.encoding == "" && rec.Header().Get("Content-Type") == "" {
+				t.Errorf("Content-Type not set")
+			}
+		})
+	}
+}
@@ -0,0 +1,193 @@
+package optionex
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSConfig configures the CORS middleware
+type CORSConfig struct {
+	// AllowedOrigins are the origins allowed, exact like
+	// "https://app.example.com", with a wildcard for subdomains like
+	// "https://*.example.com", or "*" for any origin
+	AllowedOrigins []string
+	// AllowedMethods of preflighted requests, GET, HEAD and POST by default
+	AllowedMethods []string
+	// AllowedHeaders of preflighted requests, "*" allows whatever the
+	// preflight asks for, by default only the CORS safelisted headers
+	AllowedHeaders []string
+	// ExposedHeaders are the response headers scripts may read, besides
+	// the safelisted ones
+	ExposedHeaders []string
+	// AllowCredentials lets requests carry cookies and authorization
+	// the allowed origin is then sent back instead of "*", as the spec
+	// requires
+	AllowCredentials bool
+	// MaxAge is how long browsers cache a preflight response, they cap it,
+	// Chrome at 2 hours, 0 does not send it
+	MaxAge time.Duration
+}
+
+// WithCORS adds the CORS middleware
+func WithCORS(cfg CORSConfig) Option {
+	return WithMiddleware(CORS(cfg))
+}
+
+// CORS implements cross origin resource sharing, following the fetch spec
+// preflight requests, OPTIONS with Access-Control-Request-Method, are
+// answered with 204 and never reach the handler, other requests from an
+// allowed origin get the CORS headers and are served as usual
+// requests from origins that are not allowed are served without CORS
+// headers, the browser then keeps the response from the script
+func CORS(cfg CORSConfig) Middleware {
+	if len(cfg.AllowedMethods) == 0 {
+		cfg.AllowedMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+
+	anyOrigin := slices.Contains(cfg.AllowedOrigins, "*")
+	anyHeader := slices.Contains(cfg.AllowedHeaders, "*")
+
+	origins := make([]string, len(cfg.AllowedOrigins))
+	for i, o := range cfg.AllowedOrigins {
+		origins[i] = strings.ToLower(o)
+	}
+
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	exposed := strings.Join(cfg.ExposedHeaders, ", ")
+
+	allowedHeaders := make([]string, len(cfg.AllowedHeaders))
+	for i, h := range cfg.AllowedHeaders {
+		allowedHeaders[i] = strings.ToLower(h)
+	}
+
+	allowed := func(origin string) bool {
+		if anyOrigin {
+			return true
+		}
+
+		origin = strings.ToLower(origin)
+
+		for _, o := range origins {
+			if matchOrigin(o, origin) {
+				return true
+			}
+		}
+
+		return false
+	}
+
+	// setOrigin sets the allowed origin, "*" is only sent for any origin
+	// without credentials, otherwise the response varies with the origin
+	setOrigin := func(h http.Header, origin string) {
+		if anyOrigin && !cfg.AllowCredentials {
+			h.Set("Access-Control-Allow-Origin", "*")
+		} else {
+			h.Set("Access-Control-Allow-Origin", origin)
+		}
+
+		if cfg.AllowCredentials {
+			h.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			h := w.Header()
+			origin := r.Header.Get("Origin")
+
+			// caches must not serve a response for one origin to another
+			if !anyOrigin || cfg.AllowCredentials {
+				h.Add("Vary", "Origin")
+			}
+
+			preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+			if !preflight {
+				if origin != "" && allowed(origin) {
+					setOrigin(h, origin)
+
+					if exposed != "" {
+						h.Set("Access-Control-Expose-Headers", exposed)
+					}
+				}
+
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			h.Add("Vary", "Access-Control-Request-Method")
+			h.Add("Vary", "Access-Control-Request-Headers")
+
+			if origin == "" || !allowed(origin) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			method := r.Header.Get("Access-Control-Request-Method")
+			if !slices.Contains(cfg.AllowedMethods, method) {
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+
+			requested := parseHeaderList(r.Header.Values("Access-Control-Request-Headers"))
+			if !anyHeader {
+				for _, rh := range requested {
+					if !slices.Contains(allowedHeaders, rh) {
+						w.WriteHeader(http.StatusNoContent)
+						return
+					}
+				}
+			}
+
+			setOrigin(h, origin)
+			h.Set("Access-Control-Allow-Methods", methods)
+
+			if len(requested) > 0 {
+				h.Set("Access-Control-Allow-Headers", strings.Join(requested, ", "))
+			}
+
+			if cfg.MaxAge > 0 {
+				h.Set("Access-Control-Max-Age", strconv.Itoa(int(cfg.MaxAge.Seconds())))
+			}
+
+			w.WriteHeader(http.StatusNoContent)
+		})
+	}
+}
+
+// matchOrigin matches origin against pattern, both in lower case, a "*"
+// in the pattern stands for one or more subdomain labels
+func matchOrigin(pattern, origin string) bool {
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return pattern == origin
+	}
+
+	if len(origin) <= len(prefix)+len(suffix) || !strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+
+	// the wildcard covers host labels only, never the scheme, a port or
+	// a path
+	sub := origin[len(prefix) : len(origin)-len(suffix)]
+
+	return !strings.ContainsAny(sub, "/:@")
+}
+
+// parseHeaderList splits comma separated header names, in lower case
+func parseHeaderList(values []string) []string {
+	var names []string
+
+	for _, v := range values {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, strings.ToLower(name))
+			}
+		}
+	}
+
+	return names
+}
@@ -0,0 +1,149 @@
+package optionex_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tanveerprottoy/advanced-go/pattern/optionex"
+)
+
+func TestCORS(t *testing.T) {
+	served := 0
+
+	h := optionex.CORS(optionex.CORSConfig{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPut},
+		AllowedHeaders:   []string{"Content-Type", "X-Request-ID"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		AllowCredentials: true,
+		MaxAge:           10 * time.Minute,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served++
+	}))
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		code    int
+		served  bool
+		want    map[string]string
+	}{
+		{
+			name: "simple request", method: http.MethodGet,
+			headers: map[string]string{"Origin": "https://app.example.com"},
+			code:    http.StatusOK, served: true,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":      "https://app.example.com",
+				"Access-Control-Allow-Credentials": "true",
+				"Access-Control-Expose-Headers":    "X-Request-ID",
+				"Vary":                             "Origin",
+			},
+		},
+		{
+			name: "subdomain wildcard", method: http.MethodGet,
+			headers: map[string]string{"Origin": "https://eu.api.example.org"},
+			code:    http.StatusOK, served: true,
+			want:    map[string]string{"Access-Control-Allow-Origin": "https://eu.api.example.org"},
+		},
+		{
+			name: "wildcard does not match the bare domain", method: http.MethodGet,
+			headers: map[string]string{"Origin": "https://example.org"},
+			code:    http.StatusOK, served: true,
+			want:    map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "wildcard does not match another scheme", method: http.MethodGet,
+			headers: map[string]string{"Origin": "http://a.example.org"},
+			code:    http.StatusOK, served: true,
+			want:    map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "preflight", method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  http.MethodPut,
+				"Access-Control-Request-Headers": "content-type, x-request-id",
+			},
+			code: http.StatusNoContent,
+			want: map[string]string{
+				"Access-Control-Allow-Origin":  "https://app.example.com",
+				"Access-Control-Allow-Methods": "GET, PUT",
+				"Access-Control-Allow-Headers": "content-type, x-request-id",
+				"Access-Control-Max-Age":       "600",
+			},
+		},
+		{
+			name: "preflight with a method not allowed", method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                        "https://app.example.com",
+				"Access-Control-Request-Method": http.MethodDelete,
+			},
+			code: http.StatusNoContent,
+			want: map[string]string{"Access-Control-Allow-Origin": "", "Access-Control-Allow-Methods": ""},
+		},
+		{
+			name: "preflight with a header not allowed", method: http.MethodOptions,
+			headers: map[string]string{
+				"Origin":                         "https://app.example.com",
+				"Access-Control-Request-Method":  http.MethodGet,
+				"Access-Control-Request-Headers": "Authorization",
+			},
+			code: http.StatusNoContent,
+			want: map[string]string{"Access-Control-Allow-Origin": ""},
+		},
+		{
+			name: "plain OPTIONS reaches the handler", method: http.MethodOptions,
+			headers: map[string]string{"Origin": "https://app.example.com"},
+			code:    http.StatusOK, served: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			served = 0
+
+			req := httptest.NewRequest(tc.method, "/", nil)
+			for k, v := range tc.headers {
+				req.Header.Set(k, v)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if rec.Code != tc.code {
+				t.Errorf("status = %d; want %d", rec.Code, tc.code)
+			}
+
+			if (served == 1) != tc.served {
+				t.Errorf("handler served = %v; want %v", served == 1, tc.served)
+			}
+
+			for k, v := range tc.want {
+				if got := rec.Header().Get(k); got != v {
+					t.Errorf("%s = %q; want %q", k, got, v)
+				}
+			}
+		})
+	}
+}
+
+func TestCORSAnyOrigin(t *testing.T) {
+	h := optionex.CORS(optionex.CORSConfig{AllowedOrigins: []string{"*"}})(http.NotFoundHandler())
+
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.Header.Set("Origin", "https://anywhere.test")
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "*" {
+		t.Errorf("Access-Control-Allow-Origin = %q; want *", got)
+	}
+
+	if got := rec.Header().Get("Vary"); got != "" {
+		t.Errorf("Vary = %q; want none for a response that is the same for every origin", got)
+	}
+}
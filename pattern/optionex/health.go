@@ -0,0 +1,277 @@
+package optionex
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// health endpoint paths
+const (
+	LivezPath   = "/livez"
+	ReadyzPath  = "/readyz"
+	HealthzPath = "/healthz"
+)
+
+// default settings of a health check
+const (
+	DefaultCheckTimeout  = 2 * time.Second
+	DefaultCheckCacheTTL = time.Second
+)
+
+// health statuses reported in the responses
+const (
+	HealthStatusOK       = "ok"
+	HealthStatusDegraded = "degraded"
+	HealthStatusFail     = "fail"
+)
+
+// HealthCheck reports the health of a component, a nil error means healthy
+// the check must return once ctx is done
+type HealthCheck func(ctx context.Context) error
+
+type CheckOption func(*healthCheck)
+
+// WithCheckTimeout bounds a single run of the check
+func WithCheckTimeout(t time.Duration) CheckOption {
+	return func(c *healthCheck) {
+		c.timeout = t
+	}
+}
+
+// WithCheckCacheTTL sets for how long a result is reused, so frequent
+// probes do not hammer the dependency behind the check
+func WithCheckCacheTTL(t time.Duration) CheckOption {
+	return func(c *healthCheck) {
+		c.cacheTTL = t
+	}
+}
+
+// NonCritical marks the check as non-critical, when it fails the server
+// reports itself degraded but still healthy
+func NonCritical() CheckOption {
+	return func(c *healthCheck) {
+		c.critical = false
+	}
+}
+
+// Liveness makes the check part of /livez, use it only for conditions
+// a restart fixes, like a deadlock, never for external dependencies
+// liveness checks are not part of /readyz
+func Liveness() CheckOption {
+	return func(c *healthCheck) {
+		c.liveness = true
+	}
+}
+
+// WithHealthEndpoints serves /livez, /readyz and /healthz in front of the
+// server handler, register the checks with RegisterHealthCheck
+func WithHealthEndpoints() Option {
+	return func(srv *Server) {
+		srv.healthEnabled = true
+	}
+}
+
+// CheckResult is the outcome of one health check
+type CheckResult struct {
+	Status   string    `json:"status"`
+	Critical bool      `json:"critical"`
+	Latency  string    `json:"latency"`
+	Error    string    `json:"error,omitempty"`
+	At       time.Time `json:"checked_at"`
+}
+
+// HealthReport is the body of the health endpoints
+type HealthReport struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks,omitempty"`
+}
+
+// healthCheck is a registered check with its cached result
+type healthCheck struct {
+	name     string
+	fn       HealthCheck
+	timeout  time.Duration
+	cacheTTL time.Duration
+	critical bool
+	liveness bool
+
+	// mu is held while the check runs, concurrent probes wait for the
+	// running one and share its result
+	mu     sync.Mutex
+	result CheckResult
+	ok     bool
+}
+
+func (c *healthCheck) run(ctx context.Context) (CheckResult, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.result.At.IsZero() && time.Since(c.result.At) < c.cacheTTL {
+		return c.result, c.ok
+	}
+
+	// the result is shared with other probes, so a probe that goes away
+	// must not cancel the check, the timeout bounds it instead
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := c.fn(ctx)
+
+	c.ok = err == nil
+	c.result = CheckResult{
+		Status:   HealthStatusOK,
+		Critical: c.critical,
+		Latency:  time.Since(start).String(),
+		At:       start,
+	}
+
+	if err != nil {
+		c.result.Status = HealthStatusFail
+		c.result.Error = err.Error()
+	}
+
+	return c.result, c.ok
+}
+
+// healthRegistry holds the registered checks of a server
+type healthRegistry struct {
+	mu     sync.RWMutex
+	checks []*healthCheck
+}
+
+// RegisterHealthCheck registers a named check, checks are critical and
+// part of /readyz and /healthz unless configured otherwise
+// registering a name again replaces the previous check
+func (s *Server) RegisterHealthCheck(name string, check HealthCheck, opts ...CheckOption) {
+	c := &healthCheck{
+		name:     name,
+		fn:       check,
+		timeout:  DefaultCheckTimeout,
+		cacheTTL: DefaultCheckCacheTTL,
+		critical: true,
+	}
+
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	s.health.mu.Lock()
+	defer s.health.mu.Unlock()
+
+	for i, existing := range s.health.checks {
+		if existing.name == name {
+			s.health.checks[i] = c
+			return
+		}
+	}
+
+	s.health.checks = append(s.health.checks, c)
+}
+
+// runChecks runs the selected checks concurrently and builds the report
+func (s *Server) runChecks(ctx context.Context, include func(*healthCheck) bool) (HealthReport, int) {
+	s.health.mu.RLock()
+	var checks []*healthCheck
+	for _, c := range s.health.checks {
+		if include(c) {
+			checks = append(checks, c)
+		}
+	}
+	s.health.mu.RUnlock()
+
+	type outcome struct {
+		name   string
+		result CheckResult
+		ok     bool
+	}
+
+	outcomes := make([]outcome, len(checks))
+
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			res, ok := c.run(ctx)
+			outcomes[i] = outcome{c.name, res, ok}
+		}()
+	}
+	wg.Wait()
+
+	report := HealthReport{Status: HealthStatusOK}
+	if len(outcomes) > 0 {
+		report.Checks = make(map[string]CheckResult, len(outcomes))
+	}
+
+	for _, o := range outcomes {
+		report.Checks[o.name] = o.result
+
+		switch {
+		case o.ok:
+		case o.result.Critical:
+			report.Status = HealthStatusFail
+		case report.Status == HealthStatusOK:
+			report.Status = HealthStatusDegraded
+		}
+	}
+
+	status := http.StatusOK
+	if report.Status == HealthStatusFail {
+		status = http.StatusServiceUnavailable
+	}
+
+	return report, status
+}
+
+// serveHealth writes the report for the health endpoint at path
+func (s *Server) serveHealth(w http.ResponseWriter, r *http.Request, path string) {
+	var (
+		report HealthReport
+		status int
+	)
+
+	switch path {
+	case LivezPath:
+		report, status = s.runChecks(r.Context(), func(c *healthCheck) bool { return c.liveness })
+	case ReadyzPath:
+		// a draining server is never ready, whatever its checks say
+		if !s.Ready() {
+			report, status = HealthReport{Status: HealthStatusFail}, http.StatusServiceUnavailable
+			break
+		}
+
+		report, status = s.runChecks(r.Context(), func(c *healthCheck) bool { return !c.liveness })
+	default:
+		report, status = s.runChecks(r.Context(), func(c *healthCheck) bool { return true })
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(status)
+
+	json.NewEncoder(w).Encode(report)
+}
+
+// healthHandler serves the health endpoints and passes every other
+// request to next
+func (s *Server) healthHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case LivezPath, ReadyzPath, HealthzPath:
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				w.Header().Set("Allow", "GET, HEAD")
+				http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+				return
+			}
+
+			s.serveHealth(w, r, r.URL.Path)
+		default:
+			next.ServeHTTP(w, r)
+		}
+	})
+}
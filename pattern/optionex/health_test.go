@@ -0,0 +1,104 @@
+package optionex_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/tanveerprottoy/advanced-go/pattern/optionex"
+)
+
+func getHealth(t *testing.T, h http.Handler, path string) (int, optionex.HealthReport) {
+	t.Helper()
+
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+
+	var report optionex.HealthReport
+	if err := json.NewDecoder(rec.Body).Decode(&report); err != nil {
+		t.Fatalf("decode %s: %v", path, err)
+	}
+
+	return rec.Code, report
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	srv := optionex.NewServer("127.0.0.1:0", http.NotFoundHandler(), optionex.WithHealthEndpoints())
+
+	var dbCalls atomic.Int32
+	dbErr := errors.New("connection refused")
+
+	srv.RegisterHealthCheck("db", func(ctx context.Context) error {
+		dbCalls.Add(1)
+		return dbErr
+	}, optionex.WithCheckCacheTTL(time.Minute))
+	srv.RegisterHealthCheck("cache", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}, optionex.NonCritical(), optionex.WithCheckTimeout(10*time.Millisecond))
+	srv.RegisterHealthCheck("loop", func(ctx context.Context) error {
+		return nil
+	}, optionex.Liveness())
+
+	h := srv.HTTPServer().Handler
+
+	t.Run("livez runs only liveness checks", func(t *testing.T) {
+		code, report := getHealth(t, h, optionex.LivezPath)
+		if code != http.StatusOK || report.Status != optionex.HealthStatusOK || len(report.Checks) != 1 {
+			t.Errorf("livez = %d %+v", code, report)
+		}
+	})
+
+	t.Run("readyz is off before start", func(t *testing.T) {
+		code, report := getHealth(t, h, optionex.ReadyzPath)
+		if code != http.StatusServiceUnavailable || report.Status != optionex.HealthStatusFail {
+			t.Errorf("readyz = %d %+v", code, report)
+		}
+	})
+
+	t.Run("healthz reports every check", func(t *testing.T) {
+		code, report := getHealth(t, h, optionex.HealthzPath)
+		if code != http.StatusServiceUnavailable || report.Status != optionex.HealthStatusFail {
+			t.Errorf("healthz = %d %+v", code, report)
+		}
+
+		if c := report.Checks["db"]; c.Status != optionex.HealthStatusFail || c.Error != dbErr.Error() || !c.Critical {
+			t.Errorf("db check = %+v", c)
+		}
+
+		if c := report.Checks["cache"]; c.Status != optionex.HealthStatusFail || c.Critical {
+			t.Errorf("cache check = %+v", c)
+		}
+	})
+
+	t.Run("results are cached", func(t *testing.T) {
+		getHealth(t, h, optionex.HealthzPath)
+
+		if n := dbCalls.Load(); n != 1 {
+			t.Errorf("db check ran %d times; want 1", n)
+		}
+	})
+
+	t.Run("non-critical failure degrades", func(t *testing.T) {
+		srv.RegisterHealthCheck("db", func(ctx context.Context) error { return nil })
+
+		code, report := getHealth(t, h, optionex.HealthzPath)
+		if code != http.StatusOK || report.Status != optionex.HealthStatusDegraded {
+			t.Errorf("healthz = %d %+v", code, report)
+		}
+	})
+
+	t.Run("other paths reach the handler", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/products", nil))
+
+		if rec.Code != http.StatusNotFound {
+			t.Errorf("status = %d; want 404", rec.Code)
+		}
+	})
+}
@@ -0,0 +1,312 @@
+package optionex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor systemd passes, after
+// stdin, stdout and stderr
+const listenFDsStart = 3
+
+// ErrReusePortUnsupported is returned by Start when WithReusePort is used
+// on a platform without SO_REUSEPORT
+var ErrReusePortUnsupported = errors.New("optionex: SO_REUSEPORT is not supported on this platform")
+
+// listenerSpec describes one listener of the server
+type listenerSpec struct {
+	// server serves the listener, nil means the main server
+	server *http.Server
+	// network and address to listen on, when ln is nil
+	network string
+	address string
+	// mode sets the permissions of a unix socket, 0 keeps the umask default
+	mode fs.FileMode
+	// ln is an already open listener
+	ln net.Listener
+}
+
+// WithListener serves the handler on an already open listener as well
+// the server takes ownership of ln and closes it on shutdown
+func WithListener(ln net.Listener) Option {
+	return func(srv *Server) {
+		srv.listeners = append(srv.listeners, listenerSpec{ln: ln})
+	}
+}
+
+// WithUnixSocket serves the handler on a unix domain socket as well, for
+// a proxy running on the same host
+// a stale socket file left by a previous run is removed, mode sets the
+// permissions of the socket, like 0o660 to let the proxy's group connect
+// the socket file is removed on shutdown
+func WithUnixSocket(path string, mode fs.FileMode) Option {
+	return func(srv *Server) {
+		srv.listeners = append(srv.listeners, listenerSpec{network: "unix", address: path, mode: mode})
+	}
+}
+
+// WithSystemdListeners serves the handler on the sockets passed by systemd
+// socket activation, through LISTEN_FDS and LISTEN_PID
+// when the process was socket activated the inherited sockets replace the
+// address given to NewServer, otherwise, like when running locally, the
+// server listens on the address as usual
+func WithSystemdListeners() Option {
+	return func(srv *Server) {
+		srv.systemd = true
+	}
+}
+
+// WithReusePort sets SO_REUSEPORT on the TCP listeners the server opens,
+// so several processes can listen on the same port and the kernel spreads
+// the connections among them
+// Start returns ErrReusePortUnsupported on platforms without it
+func WithReusePort() Option {
+	return func(srv *Server) {
+		srv.reusePort = true
+	}
+}
+
+// WithAdminServer runs a second server on address with its own handler,
+// for endpoints that must not be public, like metrics or pprof
+// the middlewares of the main server are not applied to it, it shares the
+// timeouts of the main server as they are when the option is applied and
+// is shut down together with it
+func WithAdminServer(address string, handler http.Handler) Option {
+	return func(srv *Server) {
+		admin := &http.Server{
+			Addr:              address,
+			Handler:           handler,
+			ReadTimeout:       srv.httpServer.ReadTimeout,
+			ReadHeaderTimeout: srv.httpServer.ReadHeaderTimeout,
+			WriteTimeout:      srv.httpServer.WriteTimeout,
+		}
+
+		srv.adminServers = append(srv.adminServers, admin)
+		srv.listeners = append(srv.listeners, listenerSpec{server: admin, network: "tcp", address: address})
+	}
+}
+
+// Addrs returns the addresses the server listens on, it is empty until
+// Start has opened the listeners
+func (s *Server) Addrs() []net.Addr {
+	s.listenersMu.Lock()
+	defer s.listenersMu.Unlock()
+
+	addrs := make([]net.Addr, 0, len(s.active))
+	for _, l := range s.active {
+		addrs = append(addrs, l.ln.Addr())
+	}
+
+	return addrs
+}
+
+// listen opens every listener of the server, on error the ones already
+// opened are closed
+func (s *Server) listen() ([]listenerSpec, error) {
+	// a process started by Upgrade takes over the listeners of its parent
+	if specs, ok, err := s.upgradeListeners(); ok || err != nil {
+		return specs, err
+	}
+
+	specs := make([]listenerSpec, 0, len(s.listeners)+1)
+
+	inherited := false
+
+	if s.systemd {
+		lns, err := systemdListeners(listenFDsStart)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, ln := range lns {
+			specs = append(specs, listenerSpec{ln: ln})
+		}
+
+		inherited = len(lns) > 0
+	}
+
+	if !inherited && (s.httpServer.Addr != "" || !s.hasMainListener()) {
+		addr := s.httpServer.Addr
+		if addr == "" {
+			addr = ":http"
+			if s.httpServer.TLSConfig != nil {
+				addr = ":https"
+			}
+		}
+
+		specs = append(specs, listenerSpec{network: "tcp", address: addr})
+	}
+
+	specs = append(specs, s.listeners...)
+
+	for i := range specs {
+		if specs[i].ln != nil {
+			continue
+		}
+
+		ln, err := s.open(specs[i])
+		if err != nil {
+			for _, opened := range specs[:i] {
+				opened.ln.Close()
+			}
+
+			// the listeners that were not reached are owned by the server
+			for _, rest := range specs[i+1:] {
+				if rest.ln != nil {
+					rest.ln.Close()
+				}
+			}
+
+			return nil, err
+		}
+
+		specs[i].ln = ln
+	}
+
+	return specs, nil
+}
+
+// hasMainListener reports whether an option added a listener served by
+// the main server
+func (s *Server) hasMainListener() bool {
+	for _, l := range s.listeners {
+		if l.server == nil {
+			return true
+		}
+	}
+
+	return false
+}
+
+// open opens the listener described by l
+func (s *Server) open(l listenerSpec) (net.Listener, error) {
+	var lc net.ListenConfig
+
+	if l.network == "unix" {
+		if err := removeStaleSocket(l.address); err != nil {
+			return nil, err
+		}
+
+		ln, err := lc.Listen(context.Background(), l.network, l.address)
+		if err != nil {
+			return nil, err
+		}
+
+		if l.mode != 0 {
+			if err := os.Chmod(l.address, l.mode); err != nil {
+				ln.Close()
+				return nil, err
+			}
+		}
+
+		return ln, nil
+	}
+
+	if s.reusePort {
+		lc.Control = reusePortControl
+	}
+
+	return lc.Listen(context.Background(), l.network, l.address)
+}
+
+// removeStaleSocket removes a socket file left behind by a process that
+// did not shut down cleanly, anything else at path is left alone and
+// listening fails
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	if err != nil {
+		return err
+	}
+
+	if fi.Mode().Type() != fs.ModeSocket {
+		return fmt.Errorf("optionex: %s exists and is not a socket", path)
+	}
+
+	return os.Remove(path)
+}
+
+// systemdListeners returns the listeners passed by socket activation,
+// following sd_listen_fds, start is the first descriptor
+// it returns nothing if the sockets were not meant for this process, and
+// unsets the variables so child processes do not inherit them
+func systemdListeners(start int) ([]net.Listener, error) {
+	pid, fds, names := os.Getenv("LISTEN_PID"), os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_FDNAMES")
+
+	if pid == "" || fds == "" {
+		return nil, nil
+	}
+
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_FDNAMES")
+
+	if p, err := strconv.Atoi(pid); err != nil || p != os.Getpid() {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 0 {
+		return nil, fmt.Errorf("optionex: invalid LISTEN_FDS %q", fds)
+	}
+
+	var fdNames []string
+	if names != "" {
+		fdNames = strings.Split(names, ":")
+	}
+
+	return fileListeners(start, n, fdNames, "systemd socket")
+}
+
+// fileListeners turns the n inherited descriptors from start on into
+// listeners, names label them in errors
+func fileListeners(start, n int, names []string, kind string) ([]net.Listener, error) {
+	lns := make([]net.Listener, 0, n)
+
+	for i := range n {
+		name := "LISTEN_FD_" + strconv.Itoa(start+i)
+		if i < len(names) {
+			name = names[i]
+		}
+
+		f := os.NewFile(uintptr(start+i), name)
+
+		// FileListener duplicates the descriptor, the original is closed
+		ln, err := net.FileListener(f)
+		f.Close()
+
+		if err != nil {
+			for _, l := range lns {
+				l.Close()
+			}
+
+			return nil, fmt.Errorf("optionex: %s %s: %w", kind, name, err)
+		}
+
+		lns = append(lns, ln)
+	}
+
+	return lns, nil
+}
+
+// servers returns the main server followed by the admin servers
+func (s *Server) servers() []*http.Server {
+	return append([]*http.Server{s.httpServer}, s.adminServers...)
+}
+
+// closeAll closes every server right away, dropping open connections
+func (s *Server) closeAll() {
+	for _, srv := range s.servers() {
+		srv.Close()
+	}
+}
@@ -0,0 +1,197 @@
+//go:build unix
+
+package optionex
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func text(s string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, s)
+	})
+}
+
+func getBody(t *testing.T, client *http.Client, url string) string {
+	t.Helper()
+
+	resp, err := client.Get(url)
+	if err != nil {
+		t.Fatalf("Get %s: %v", url, err)
+	}
+	defer resp.Body.Close()
+
+	b, _ := io.ReadAll(resp.Body)
+
+	return string(b)
+}
+
+func TestUnixSocketAndAdminServer(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "app.sock")
+
+	// a stale socket file from a previous run
+	stale, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	srv := NewServer("", text("main"),
+		WithUnixSocket(sock, 0o660),
+		WithAdminServer("127.0.0.1:0", text("admin")),
+	)
+
+	started := make(chan error, 1)
+	go func() { started <- srv.Start() }()
+
+	for deadline := time.Now().Add(time.Second); !srv.Ready(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("server not ready")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	addrs := srv.Addrs()
+	if len(addrs) != 2 {
+		t.Fatalf("Addrs() = %v; want the socket and the admin address", addrs)
+	}
+
+	fi, err := os.Stat(sock)
+	if err != nil {
+		t.Fatalf("Stat: %v", err)
+	}
+
+	if perm := fi.Mode().Perm(); perm != 0o660 {
+		t.Errorf("socket permissions = %v; want 0660", perm)
+	}
+
+	unixClient := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", sock)
+		},
+	}}
+
+	if got := getBody(t, unixClient, "http://unix/"); got != "main" {
+		t.Errorf("unix socket body = %q; want main", got)
+	}
+
+	if got := getBody(t, http.DefaultClient, "http://"+addrs[1].String()+"/"); got != "admin" {
+		t.Errorf("admin body = %q; want admin", got)
+	}
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if err := <-started; err != nil {
+		t.Errorf("Start: %v", err)
+	}
+
+	if _, err := os.Stat(sock); !os.IsNotExist(err) {
+		t.Errorf("socket file left behind after shutdown")
+	}
+}
+
+func TestUnixSocketRefusesRegularFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data")
+	os.WriteFile(path, []byte("keep me"), 0o600)
+
+	srv := NewServer("", nil, WithUnixSocket(path, 0))
+
+	if err := srv.Start(); err == nil {
+		t.Fatalf("Start err = nil; want an error for a path that is not a socket")
+	}
+
+	if b, _ := os.ReadFile(path); string(b) != "keep me" {
+		t.Errorf("the file at the socket path was modified")
+	}
+}
+
+// inheritFD returns a descriptor of a fresh listener, as systemd would
+// pass it
+func inheritFD(t *testing.T) (fd int, addr string) {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+	defer ln.Close()
+
+	f, err := ln.(*net.TCPListener).File()
+	if err != nil {
+		t.Fatalf("File: %v", err)
+	}
+	defer f.Close()
+
+	// the copy is owned by systemdListeners
+	fd, err = syscall.Dup(int(f.Fd()))
+	if err != nil {
+		t.Fatalf("Dup: %v", err)
+	}
+
+	return fd, ln.Addr().String()
+}
+
+func TestSystemdListeners(t *testing.T) {
+	t.Run("inherits the sockets of this process", func(t *testing.T) {
+		fd, addr := inheritFD(t)
+
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()))
+		t.Setenv("LISTEN_FDS", "1")
+		t.Setenv("LISTEN_FDNAMES", "http")
+
+		lns, err := systemdListeners(fd)
+		if err != nil {
+			t.Fatalf("systemdListeners: %v", err)
+		}
+
+		if len(lns) != 1 || lns[0].Addr().String() != addr {
+			t.Fatalf("systemdListeners = %v; want one listener on %s", lns, addr)
+		}
+		lns[0].Close()
+
+		for _, k := range []string{"LISTEN_PID", "LISTEN_FDS", "LISTEN_FDNAMES"} {
+			if _, ok := os.LookupEnv(k); ok {
+				t.Errorf("%s is still set", k)
+			}
+		}
+	})
+
+	t.Run("ignores sockets meant for another process", func(t *testing.T) {
+		t.Setenv("LISTEN_PID", strconv.Itoa(os.Getpid()+1))
+		t.Setenv("LISTEN_FDS", "1")
+
+		lns, err := systemdListeners(listenFDsStart)
+		if err != nil || len(lns) != 0 {
+			t.Errorf("systemdListeners = %v, %v; want nothing", lns, err)
+		}
+	})
+}
+
+func TestReusePort(t *testing.T) {
+	srv := NewServer("", nil, WithReusePort())
+
+	first, err := srv.open(listenerSpec{network: "tcp", address: "127.0.0.1:0"})
+	if err != nil {
+		t.Fatalf("open: %v", err)
+	}
+	defer first.Close()
+
+	second, err := srv.open(listenerSpec{network: "tcp", address: first.Addr().String()})
+	if err != nil {
+		t.Fatalf("second listener on %s: %v", first.Addr(), err)
+	}
+	second.Close()
+}
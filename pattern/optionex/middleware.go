@@ -0,0 +1,193 @@
+package optionex
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"runtime/debug"
+	"time"
+)
+
+// Middleware wraps an http.Handler with extra behaviour
+type Middleware func(http.Handler) http.Handler
+
+// DefaultRequestIDHeader is the header used by RequestID when none is given
+const DefaultRequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength bounds a propagated request ID, longer ones are replaced
+const maxRequestIDLength = 128
+
+type requestIDKey struct{}
+
+// WithMiddleware adds middlewares to the server
+// middlewares run in the order the options are given, the first one
+// added is the outermost, it sees the request first and the response last
+func WithMiddleware(mws ...Middleware) Option {
+	return func(srv *Server) {
+		srv.middlewares = append(srv.middlewares, mws...)
+	}
+}
+
+// WithRequestID adds the RequestID middleware
+func WithRequestID(header string) Option {
+	return WithMiddleware(RequestID(header))
+}
+
+// WithRecovery adds the Recovery middleware
+func WithRecovery(logger *slog.Logger) Option {
+	return WithMiddleware(Recovery(logger))
+}
+
+// WithAccessLog adds the AccessLog middleware
+func WithAccessLog(logger *slog.Logger) Option {
+	return WithMiddleware(AccessLog(logger))
+}
+
+// chain wraps h with mws, mws[0] ends up outermost
+func chain(h http.Handler, mws []Middleware) http.Handler {
+	for i := len(mws) - 1; i >= 0; i-- {
+		h = mws[i](h)
+	}
+
+	return h
+}
+
+// RequestIDFromContext returns the request ID stored by the RequestID
+// middleware, or an empty string
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// newRequestID generates a random 128 bit request ID
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+
+	return hex.EncodeToString(b)
+}
+
+// validRequestID reports whether a client supplied ID is safe to log and echo back
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+
+	for i := 0; i < len(id); i++ {
+		// printable ascii without space
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RequestID propagates the request ID from the header, or generates a new
+// one, stores it in the request context and sets it on the response
+// header defaults to DefaultRequestIDHeader
+func RequestID(header string) Middleware {
+	if header == "" {
+		header = DefaultRequestIDHeader
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			id := r.Header.Get(header)
+			if !validRequestID(id) {
+				id = newRequestID()
+			}
+
+			w.Header().Set(header, id)
+
+			ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
+	}
+}
+
+// Recovery recovers from panics in the handler, logs the panic with the
+// stack trace and responds with 500 Internal Server Error if nothing
+// has been written yet
+// http.ErrAbortHandler is passed through, it is the way to abort a response
+// logger defaults to slog.Default
+func Recovery(logger *slog.Logger) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := wrapResponseWriter(w)
+
+			defer func() {
+				v := recover()
+				if v == nil {
+					return
+				}
+
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+
+				logger.ErrorContext(
+					r.Context(),
+					"panic recovered",
+					slog.Any("panic", v),
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("request_id", RequestIDFromContext(r.Context())),
+					slog.String("stack", string(debug.Stack())),
+				)
+
+				if !rw.Written() {
+					http.Error(rw, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+				}
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// AccessLog logs one line for every request, with the status, the bytes
+// written, the duration and the remote address
+// logger defaults to slog.Default
+func AccessLog(logger *slog.Logger) Middleware {
+	if logger == nil {
+		logger = slog.Default()
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+			rw := wrapResponseWriter(w)
+
+			// log even when the handler panics, the status of a response
+			// that was never written is logged as 0
+			defer func() {
+				attrs := []slog.Attr{
+					slog.String("method", r.Method),
+					slog.String("path", r.URL.Path),
+					slog.String("proto", r.Proto),
+					slog.Int("status", rw.Status()),
+					slog.Int64("bytes", rw.bytes),
+					slog.Duration("duration", time.Since(start)),
+					slog.String("remote_addr", r.RemoteAddr),
+					slog.String("user_agent", r.UserAgent()),
+				}
+
+				if id := RequestIDFromContext(r.Context()); id != "" {
+					attrs = append(attrs, slog.String("request_id", id))
+				}
+
+				logger.LogAttrs(r.Context(), slog.LevelInfo, "http request", attrs...)
+			}()
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
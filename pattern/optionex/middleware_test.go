@@ -0,0 +1,149 @@
+package optionex_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/tanveerprottoy/advanced-go/pattern/optionex"
+)
+
+func TestRequestID(t *testing.T) {
+	var got string
+
+	h := optionex.RequestID("")(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = optionex.RequestIDFromContext(r.Context())
+	}))
+
+	tests := []struct {
+		name     string
+		incoming string
+		keep     bool
+	}{
+		{"generates when missing", "", false},
+		{"propagates a valid id", "abc-123", true},
+		{"replaces an invalid id", "bad id\n", false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tc.incoming != "" {
+				req.Header.Set(optionex.DefaultRequestIDHeader, tc.incoming)
+			}
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, req)
+
+			if got == "" {
+				t.Fatalf("request ID missing from context")
+			}
+
+			if (got == tc.incoming) != tc.keep {
+				t.Errorf("request ID = %q; incoming %q, keep %v", got, tc.incoming, tc.keep)
+			}
+
+			if h := rec.Header().Get(optionex.DefaultRequestIDHeader); h != got {
+				t.Errorf("response header = %q; want %q", h, got)
+			}
+		})
+	}
+}
+
+func TestRecoveryAndAccessLog(t *testing.T) {
+	var logs bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&logs, nil))
+
+	handler := http.NewServeMux()
+	handler.HandleFunc("/panic", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	})
+	handler.HandleFunc("/stream", func(w http.ResponseWriter, r *http.Request) {
+		// the wrapper must keep the writer flushable for streaming handlers
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("ResponseWriter does not implement http.Flusher")
+		}
+
+		w.Write([]byte("hello"))
+	})
+
+	srv := optionex.NewServer(
+		":0",
+		handler,
+		optionex.WithRequestID(""),
+		optionex.WithAccessLog(logger),
+		optionex.WithRecovery(logger),
+	)
+
+	rec := httptest.NewRecorder()
+	srv.HTTPServer().Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/panic", nil))
+
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("panic status = %d; want 500", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	srv.HTTPServer().Handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/stream", nil))
+
+	var entries []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(logs.String()), "\n") {
+		var e map[string]any
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid log line %q: %v", line, err)
+		}
+
+		entries = append(entries, e)
+	}
+
+	// panic log, access log for /panic, access log for /stream
+	if len(entries) != 3 {
+		t.Fatalf("got %d log entries; want 3: %s", len(entries), logs.String())
+	}
+
+	if entries[0]["panic"] != "boom" || entries[0]["stack"] == "" {
+		t.Errorf("panic entry = %v", entries[0])
+	}
+
+	if entries[1]["status"] != float64(500) {
+		t.Errorf("access log status = %v; want 500", entries[1]["status"])
+	}
+
+	if entries[2]["status"] != float64(200) || entries[2]["bytes"] != float64(5) || entries[2]["request_id"] == nil {
+		t.Errorf("access log entry = %v", entries[2])
+	}
+}
+
+func TestResponseWriterHijack(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, brw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			t.Errorf("Hijack: %v", err)
+			return
+		}
+
+		defer conn.Close()
+
+		brw.WriteString("HTTP/1.1 204 No Content\r\n\r\n")
+		brw.Flush()
+	})
+
+	srv := optionex.NewServer(":0", handler, optionex.WithAccessLog(slog.New(slog.DiscardHandler)))
+
+	ts := httptest.NewServer(srv.HTTPServer().Handler)
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatalf("http.Get: %v", err)
+	}
+
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		t.Errorf("status = %d; want 204", resp.StatusCode)
+	}
+}
@@ -0,0 +1,252 @@
+package optionex
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRateLimitMaxKeys bounds the number of client buckets kept in memory
+const DefaultRateLimitMaxKeys = 10000
+
+// KeyFunc returns the key a request is rate limited by
+type KeyFunc func(r *http.Request) string
+
+// KeyByIP keys requests by the client IP address of the connection
+// behind a proxy use KeyByHeader with the header the proxy sets instead
+func KeyByIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// KeyByHeader keys requests by the value of a header, like an API key or
+// X-Real-IP set by a trusted proxy, requests without the header fall
+// back to KeyByIP
+func KeyByHeader(name string) KeyFunc {
+	return func(r *http.Request) string {
+		if v := r.Header.Get(name); v != "" {
+			return name + ":" + v
+		}
+
+		return KeyByIP(r)
+	}
+}
+
+// RateLimitConfig configures the RateLimit middleware
+type RateLimitConfig struct {
+	Rate    float64 // requests allowed per second and key, in the long run
+	Burst   int     // requests allowed at once, the size of the bucket
+	Key     KeyFunc // defaults to KeyByIP
+	MaxKeys int     // most recently used keys kept, defaults to DefaultRateLimitMaxKeys
+}
+
+// WithRateLimit adds the RateLimit middleware
+func WithRateLimit(cfg RateLimitConfig) Option {
+	return WithMiddleware(RateLimit(cfg))
+}
+
+// RateLimit limits requests per client key with token buckets
+// rejected requests get 429 Too Many Requests with Retry-After, unless
+// cfg.Rate is 0 or less and the bucket is never refilled, every
+// response carries the RateLimit-Limit, RateLimit-Remaining and
+// RateLimit-Reset headers
+// the buckets of the least recently seen keys are dropped once there are
+// more than cfg.MaxKeys, a dropped key starts again with a full bucket
+func RateLimit(cfg RateLimitConfig) Middleware {
+	if cfg.Burst <= 0 {
+		cfg.Burst = 1
+	}
+
+	if cfg.Key == nil {
+		cfg.Key = KeyByIP
+	}
+
+	if cfg.MaxKeys <= 0 {
+		cfg.MaxKeys = DefaultRateLimitMaxKeys
+	}
+
+	buckets := newBucketCache(cfg.MaxKeys)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			res := buckets.take(cfg.Key(r), cfg.Rate, cfg.Burst, time.Now())
+
+			h := w.Header()
+			h.Set("RateLimit-Limit", strconv.Itoa(cfg.Burst))
+			h.Set("RateLimit-Remaining", strconv.Itoa(res.remaining))
+			h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.reset)))
+
+			if !res.allowed {
+				// without refill there is no time worth retrying after
+				if res.retryAfter > 0 {
+					h.Set("Retry-After", strconv.Itoa(ceilSeconds(res.retryAfter)))
+				}
+
+				http.Error(w, http.StatusText(http.StatusTooManyRequests), http.StatusTooManyRequests)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ceilSeconds rounds d up to whole seconds, as the headers require
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// tokenBucket holds up to burst tokens, refilled at rate per second
+type tokenBucket struct {
+	key    string
+	tokens float64
+	last   time.Time
+}
+
+// takeResult is the outcome of taking a token
+type takeResult struct {
+	allowed    bool
+	remaining  int
+	retryAfter time.Duration // until the next token, when not allowed, 0 if never
+	reset      time.Duration // until the bucket is full again
+}
+
+func (b *tokenBucket) take(rate float64, burst int, now time.Time) takeResult {
+	// refill for the time passed since the last take
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	res := takeResult{allowed: b.tokens >= 1}
+	if res.allowed {
+		b.tokens--
+	}
+
+	res.remaining = int(b.tokens)
+
+	if rate > 0 {
+		res.reset = time.Duration((float64(burst) - b.tokens) / rate * float64(time.Second))
+
+		if !res.allowed {
+			res.retryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+		}
+	}
+
+	return res
+}
+
+// bucketCache is an LRU cache of token buckets
+type bucketCache struct {
+	mu    sync.Mutex
+	max   int
+	order *list.List // front is the most recently used
+	items map[string]*list.Element
+}
+
+func newBucketCache(max int) *bucketCache {
+	return &bucketCache{
+		max:   max,
+		order: list.New(),
+		items: make(map[string]*list.Element),
+	}
+}
+
+func (c *bucketCache) take(key string, rate float64, burst int, now time.Time) takeResult {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if ok {
+		c.order.MoveToFront(e)
+	} else {
+		e = c.order.PushFront(&tokenBucket{key: key, tokens: float64(burst), last: now})
+		c.items[key] = e
+
+		if c.order.Len() > c.max {
+			oldest := c.order.Back()
+			c.order.Remove(oldest)
+			delete(c.items, oldest.Value.(*tokenBucket).key)
+		}
+	}
+
+	return e.Value.(*tokenBucket).take(rate, burst, now)
+}
+
+// ConcurrencyLimitConfig configures the ConcurrencyLimit middleware
+type ConcurrencyLimitConfig struct {
+	MaxInFlight  int           // requests handled at once
+	MaxQueue     int           // requests waiting for a slot, 0 means none wait
+	QueueTimeout time.Duration // longest wait for a slot, 0 means until the client goes away
+}
+
+// WithConcurrencyLimit adds the ConcurrencyLimit middleware
+func WithConcurrencyLimit(cfg ConcurrencyLimitConfig) Option {
+	return WithMiddleware(ConcurrencyLimit(cfg))
+}
+
+// ConcurrencyLimit bounds the requests in flight across all clients
+// once cfg.MaxInFlight requests are running, up to cfg.MaxQueue more wait
+// for a slot, everything beyond that, and every request that waited longer
+// than cfg.QueueTimeout, is shed with 503 Service Unavailable
+func ConcurrencyLimit(cfg ConcurrencyLimitConfig) Middleware {
+	if cfg.MaxInFlight <= 0 {
+		cfg.MaxInFlight = 1
+	}
+
+	// the buffered channel is a semaphore, a send acquires a slot
+	slots := make(chan struct{}, cfg.MaxInFlight)
+
+	var queued atomic.Int64
+
+	shed := func(w http.ResponseWriter) {
+		w.Header().Set("Retry-After", "1")
+		http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+			default:
+				// no free slot, wait in the queue if there is room
+				if queued.Add(1) > int64(cfg.MaxQueue) {
+					queued.Add(-1)
+					shed(w)
+					return
+				}
+
+				var timeout <-chan time.Time
+				if cfg.QueueTimeout > 0 {
+					t := time.NewTimer(cfg.QueueTimeout)
+					defer t.Stop()
+					timeout = t.C
+				}
+
+				select {
+				case slots <- struct{}{}:
+					queued.Add(-1)
+				case <-timeout:
+					queued.Add(-1)
+					shed(w)
+					return
+				case <-r.Context().Done():
+					// the client is gone, nobody reads the response
+					queued.Add(-1)
+					return
+				}
+			}
+
+			defer func() { <-slots }()
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
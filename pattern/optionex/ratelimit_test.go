@@ -0,0 +1,129 @@
+package optionex_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/tanveerprottoy/advanced-go/pattern/optionex"
+)
+
+func TestRateLimit(t *testing.T) {
+	h := optionex.RateLimit(optionex.RateLimitConfig{
+		Rate:    1,
+		Burst:   2,
+		Key:     optionex.KeyByHeader("X-API-Key"),
+		MaxKeys: 1,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	do := func(key string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("X-API-Key", key)
+
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, req)
+
+		return rec
+	}
+
+	tests := []struct {
+		key       string
+		code      int
+		remaining string
+	}{
+		{"a", http.StatusOK, "1"},
+		{"a", http.StatusOK, "0"},
+		{"a", http.StatusTooManyRequests, "0"},
+		// b evicts a, only one key is kept
+		{"b", http.StatusOK, "1"},
+		{"a", http.StatusOK, "1"},
+	}
+
+	for i, tc := range tests {
+		rec := do(tc.key)
+
+		if rec.Code != tc.code {
+			t.Errorf("request %d (%s): status = %d; want %d", i, tc.key, rec.Code, tc.code)
+		}
+
+		if got := rec.Header().Get("RateLimit-Remaining"); got != tc.remaining {
+			t.Errorf("request %d (%s): RateLimit-Remaining = %s; want %s", i, tc.key, got, tc.remaining)
+		}
+
+		if rec.Code == http.StatusTooManyRequests && rec.Header().Get("Retry-After") != "1" {
+			t.Errorf("request %d (%s): Retry-After = %q; want 1", i, tc.key, rec.Header().Get("Retry-After"))
+		}
+	}
+}
+
+func TestRateLimitNoRefill(t *testing.T) {
+	h := optionex.RateLimit(optionex.RateLimitConfig{Burst: 1})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for i, want := range []int{http.StatusOK, http.StatusTooManyRequests} {
+		rec := httptest.NewRecorder()
+		h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+		if rec.Code != want {
+			t.Errorf("request %d: code = %d; want %d", i, rec.Code, want)
+		}
+
+		if ra := rec.Header().Get("Retry-After"); ra != "" {
+			t.Errorf("request %d: Retry-After = %q; want none, the bucket is never refilled", i, ra)
+		}
+	}
+}
+
+func TestConcurrencyLimit(t *testing.T) {
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+
+	h := optionex.ConcurrencyLimit(optionex.ConcurrencyLimitConfig{
+		MaxInFlight:  1,
+		MaxQueue:     1,
+		QueueTimeout: time.Second,
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		started <- struct{}{}
+		<-release
+	}))
+
+	var wg sync.WaitGroup
+	codes := make([]int, 2)
+
+	// the first request runs, the second one waits in the queue
+	for i := range codes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+			codes[i] = rec.Code
+		}()
+
+		if i == 0 {
+			<-started
+		}
+	}
+
+	// give the second request time to enter the queue
+	time.Sleep(50 * time.Millisecond)
+
+	// the queue is full, the third request is shed
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("shed request status = %d; want 503", rec.Code)
+	}
+
+	close(release)
+	wg.Wait()
+
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Errorf("request %d status = %d; want 200", i, code)
+		}
+	}
+}
@@ -0,0 +1,101 @@
+package optionex
+
+import (
+	"bufio"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// responseWriter wraps an http.ResponseWriter to record the status code
+// and the number of bytes written, for access logs and panic recovery
+// it keeps http.Flusher and http.Hijacker working for streaming and
+// websocket handlers, and Unwrap lets http.ResponseController reach the
+// underlying writer
+type responseWriter struct {
+	http.ResponseWriter
+	status      int
+	bytes       int64
+	wroteHeader bool
+	hijacked    bool
+}
+
+// wrapResponseWriter returns w as a *responseWriter, it does not wrap twice
+func wrapResponseWriter(w http.ResponseWriter) *responseWriter {
+	if rw, ok := w.(*responseWriter); ok {
+		return rw
+	}
+
+	return &responseWriter{ResponseWriter: w}
+}
+
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+
+	// informational responses, 1xx, can be followed by the final one
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		rw.ResponseWriter.WriteHeader(code)
+		return
+	}
+
+	rw.status = code
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+
+	return n, err
+}
+
+// Status returns the status code written, 200 if the handler only wrote
+// a body and 0 if it wrote nothing
+func (rw *responseWriter) Status() int {
+	return rw.status
+}
+
+// Written reports whether the header has been sent
+func (rw *responseWriter) Written() bool {
+	return rw.wroteHeader || rw.hijacked
+}
+
+func (rw *responseWriter) Flush() {
+	if !rw.wroteHeader {
+		rw.WriteHeader(http.StatusOK)
+	}
+
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (rw *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := rw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("optionex: underlying ResponseWriter does not implement http.Hijacker")
+	}
+
+	conn, brw, err := h.Hijack()
+	if err == nil {
+		rw.hijacked = true
+
+		if rw.status == 0 {
+			rw.status = http.StatusSwitchingProtocols
+		}
+	}
+
+	return conn, brw, err
+}
+
+// Unwrap is used by http.ResponseController
+func (rw *responseWriter) Unwrap() http.ResponseWriter {
+	return rw.ResponseWriter
+}
@@ -0,0 +1,7 @@
+//go:build !386 && !amd64 && !arm
+
+package optionex
+
+import "syscall"
+
+const soReusePort = syscall.SO_REUSEPORT
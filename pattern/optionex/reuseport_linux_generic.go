@@ -0,0 +1,7 @@
+//go:build linux && (386 || amd64 || arm)
+
+package optionex
+
+// soReusePort is SO_REUSEPORT, the syscall package lacks it on these
+// architectures, they use the asm-generic value, unlike mips or sparc
+const soReusePort = 0xf
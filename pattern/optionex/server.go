@@ -5,10 +5,14 @@ package optionex
 
 import (
 	"context"
+	"expvar"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"os/signal"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"time"
 )
 
@@ -37,10 +41,44 @@ func WithWriteTimeout(t time.Duration) Option {
 
 type Server struct {
 	httpServer *http.Server
+	// middlewares wrap the handler, in the order they were added
+	middlewares []Middleware
+	// health endpoints and their registered checks
+	healthEnabled bool
+	health        healthRegistry
+	// listeners opened by Start besides the address, admin servers
+	// have their own handler
+	listeners    []listenerSpec
+	adminServers []*http.Server
+	systemd      bool
+	reusePort    bool
+	// active holds the listeners Start opened, for Addrs
+	listenersMu sync.Mutex
+	active      []listenerSpec
+	// binary upgrades, readyPipe is the pipe to the parent process
+	// when this process was started by Upgrade
+	upgradeSignals []os.Signal
+	upgradeTimeout time.Duration
+	upgradeMu      sync.Mutex
+	readyPipe      *os.File
+	handedOff      atomic.Bool
+	upgradeDrained chan struct{}
+	newConns       sync.Map // of net.Conn
+	// initErr collects errors of options that could not be applied,
+	// Start returns it
+	initErr error
 	// empty struct consumes zero memory
 	// this channel is used to wait for idle connections to be closed
 	// before shutting down the server
 	idleConnsClosed chan struct{}
+
+	// graceful shutdown
+	ready           atomic.Bool
+	shutdownTimeout time.Duration
+	shutdownSignals []os.Signal
+	shutdownHooks   []shutdownHook
+	shutdownOnce    sync.Once
+	shutdownErr     error
 }
 
 // NewServer initializes the server
@@ -50,66 +88,144 @@ func NewServer(address string, handler http.Handler, opts ...Option) *Server {
 			Addr:    address,
 			Handler: handler,
 		},
+		shutdownTimeout: DefaultShutdownTimeout,
+		shutdownSignals: []os.Signal{os.Interrupt, syscall.SIGTERM},
 	}
 
 	for _, opt := range opts {
 		opt(srv)
 	}
 
-	return srv
-}
+	if len(srv.middlewares) > 0 || srv.healthEnabled {
+		// a nil handler means http.DefaultServeMux, the middlewares
+		// need something concrete to wrap
+		if handler == nil {
+			handler = http.DefaultServeMux
+		}
+
+		// the health endpoints sit inside the middlewares, so probes
+		// are logged and get a request ID like any other request
+		if srv.healthEnabled {
+			handler = srv.healthHandler(handler)
+		}
 
-func (s *Server) Shutdown() {
-	if err := s.httpServer.Shutdown(context.Background()); err != nil {
-		// Error from closing listeners, or context timeout:
-		log.Printf("HTTP server shutdown error: %v", err)
+		srv.httpServer.Handler = chain(handler, srv.middlewares)
 	}
+
+	return srv
 }
 
-// ConfigureGracefulShutdown configures graceful shutdown
-func (s *Server) ConfigureGracefulShutdown(defferedFunc func()) {
-	// code to support graceful shutdown
-	s.idleConnsClosed = make(chan struct{})
-	go func() {
-		// this func listens for SIGINT and initiates
-		// shutdown when SIGINT is received
-		ch := make(chan os.Signal, 1)
+// Start starts the server, it blocks until the server stops
+// when graceful shutdown is configured it returns after the shutdown has
+// finished, with the shutdown error if the drain timed out or a hook failed
+func (s *Server) Start() error {
+	log.Println("server starting")
 
-		// register ch to receive interrupt signal
-		signal.Notify(ch, os.Interrupt)
+	if s.initErr != nil {
+		return s.initErr
+	}
 
-		// this will block, wait for signal
-		// receive data from ch
-		<-ch
+	lns, err := s.listen()
+	if err != nil {
+		return err
+	}
 
-		// Received an interrupt signal, shut down.
-		log.Printf("Received an interrupt signal")
+	s.listenersMu.Lock()
+	s.active = lns
+	s.listenersMu.Unlock()
 
-		if defferedFunc != nil {
-			defer defferedFunc()
-		}
+	for _, srv := range s.servers() {
+		s.trackNewConns(srv)
+	}
 
-		s.Shutdown()
+	if len(s.upgradeSignals) > 0 {
+		stop := s.handleUpgrades()
+		defer stop()
+	}
 
-		// close the idle connection close channel
-		close(s.idleConnsClosed)
-	}()
-}
+	// the server accepts connections from here on
+	s.ready.Store(true)
 
-// Start starts the server
-func (s *Server) Start() {
-	log.Println("server starting")
+	// the parent process, if any, can drain now
+	s.notifyUpgraded()
 
-	// if err == http.ErrServerClosed do nothing
-	if err := s.httpServer.ListenAndServe(); err != http.ErrServerClosed {
+	// if err == http.ErrServerClosed the server was shut down
+	if err := s.serveAll(lns); err != http.ErrServerClosed {
 		// Error starting or closing listener:
-		log.Fatalf("HTTP server ListenAndServe: %v", err)
+		s.ready.Store(false)
+		return err
+	}
+
+	// after an upgrade the drain runs in the background, wait for it
+	if s.handedOff.Load() {
+		<-s.upgradeDrained
+
+		log.Println("server shutdown")
+
+		return s.shutdownErr
+	}
+
+	// graceful shutdown was never configured, nothing to wait for
+	if s.idleConnsClosed == nil {
+		log.Println("server shutdown")
+		return nil
 	}
 
 	// wait for idle connections to be closed
 	<-s.idleConnsClosed
 
 	log.Println("server shutdown")
+
+	return s.shutdownErr
+}
+
+// serveAll serves every listener until the servers are shut down
+// a listener that fails closes all the servers, the first error is
+// returned, or http.ErrServerClosed after a shutdown
+func (s *Server) serveAll(lns []listenerSpec) error {
+	errs := make(chan error, len(lns))
+
+	for _, l := range lns {
+		go func() {
+			if l.server != nil {
+				errs <- l.server.Serve(l.ln)
+				return
+			}
+
+			errs <- s.serve(l.ln)
+		}()
+	}
+
+	var first error
+
+	for range lns {
+		err := <-errs
+
+		// the listeners were closed by an upgrade
+		if err != http.ErrServerClosed && s.handedOff.Load() {
+			err = http.ErrServerClosed
+		}
+
+		if first == nil || first == http.ErrServerClosed && err != http.ErrServerClosed {
+			if err != http.ErrServerClosed {
+				s.closeAll()
+			}
+
+			first = err
+		}
+	}
+
+	return first
+}
+
+// serve serves HTTPS when TLS is configured, HTTP otherwise
+func (s *Server) serve(ln net.Listener) error {
+	if s.httpServer.TLSConfig != nil {
+		// the certificates come from the TLS config
+		return s.httpServer.ServeTLS(ln, "", "")
+	}
+
+	return s.httpServer.Serve(ln)
 }
 
 // HTTPServer returns the http server
@@ -126,8 +242,31 @@ func Executer() {
 		WithReadTimeout(10*time.Second),
 		WithReadHeaderTimeout(10*time.Second),
 		WithWriteTimeout(10*time.Second),
+		// the request ID goes first so the access log and the panic
+		// log can include it
+		WithRequestID(""),
+		WithAccessLog(nil),
+		WithRecovery(nil),
+		WithHealthEndpoints(),
+		// metrics stay on an internal port
+		WithAdminServer("127.0.0.1:9090", expvar.Handler()),
+		// kill -USR2 starts the new binary on the same sockets
+		WithUpgrade(),
 	)
 
+	srv.RegisterHealthCheck("self", func(ctx context.Context) error {
+		return nil
+	}, Liveness())
+
+	srv.RegisterShutdownHook("flush logs", time.Second, func(ctx context.Context) error {
+		log.Println("flushing logs")
+		return nil
+	})
+
+	srv.ConfigureGracefulShutdown(nil)
+
 	// start the server
-	srv.Start()
+	if err := srv.Start(); err != nil {
+		log.Printf("server stopped with error: %v", err)
+	}
 }
@@ -0,0 +1,180 @@
+package optionex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"time"
+)
+
+// DefaultShutdownTimeout bounds how long the server waits for in flight
+// requests to finish once shutdown starts
+const DefaultShutdownTimeout = 30 * time.Second
+
+// ErrShutdownTimeout is returned when in flight requests did not finish
+// within the shutdown timeout and the remaining connections were closed
+var ErrShutdownTimeout = errors.New("optionex: shutdown timed out, connections were force closed")
+
+// WithShutdownTimeout sets how long the server drains in flight requests
+// before it force closes the remaining connections
+func WithShutdownTimeout(t time.Duration) Option {
+	return func(srv *Server) {
+		srv.shutdownTimeout = t
+	}
+}
+
+// WithShutdownSignals sets the signals that start graceful shutdown,
+// SIGINT and SIGTERM by default
+func WithShutdownSignals(sigs ...os.Signal) Option {
+	return func(srv *Server) {
+		srv.shutdownSignals = sigs
+	}
+}
+
+// shutdownHook is a named cleanup step run during shutdown
+type shutdownHook struct {
+	name    string
+	timeout time.Duration
+	fn      func(ctx context.Context) error
+}
+
+// RegisterShutdownHook registers fn to run after the server has stopped
+// serving requests, for closing databases, flushing buffers and similar
+// hooks run in registration order, each one with its own deadline of
+// timeout, or of the shutdown timeout if timeout is 0
+// it must be called before the server is shut down
+func (s *Server) RegisterShutdownHook(name string, timeout time.Duration, fn func(ctx context.Context) error) {
+	s.shutdownHooks = append(s.shutdownHooks, shutdownHook{name: name, timeout: timeout, fn: fn})
+}
+
+// Ready reports whether the server accepts new work, it is false before
+// Start and from the moment shutdown begins
+func (s *Server) Ready() bool {
+	return s.ready.Load()
+}
+
+// Shutdown gracefully shuts down the server, it marks the server not
+// ready, stops accepting connections and waits for in flight requests
+// for up to the shutdown timeout, or until ctx is done
+// connections still open after that are force closed and the returned
+// error wraps ErrShutdownTimeout, then the shutdown hooks run
+// only the first call does the work, later calls return its result
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.shutdownOnce.Do(func() {
+		s.shutdownErr = s.shutdown(ctx)
+
+		// let Start return, if graceful shutdown was configured
+		if s.idleConnsClosed != nil {
+			close(s.idleConnsClosed)
+		}
+	})
+
+	return s.shutdownErr
+}
+
+func (s *Server) shutdown(ctx context.Context) error {
+	// stop advertising readiness first, so load balancers stop routing here
+	s.ready.Store(false)
+
+	drainCtx, cancel := context.WithTimeout(ctx, s.shutdownTimeout)
+	defer cancel()
+
+	var errs []error
+
+	// the servers drain together, sharing the deadline
+	servers := s.servers()
+	drainErrs := make([]error, len(servers))
+
+	var wg sync.WaitGroup
+
+	for i, srv := range servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			if err := srv.Shutdown(drainCtx); err != nil {
+				// the drain did not finish in time, close whatever is left
+				srv.Close()
+
+				drainErrs[i] = fmt.Errorf("%w: %w", ErrShutdownTimeout, err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	for _, err := range drainErrs {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for _, h := range s.shutdownHooks {
+		timeout := h.timeout
+		if timeout <= 0 {
+			timeout = s.shutdownTimeout
+		}
+
+		hookCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), timeout)
+
+		if err := h.fn(hookCtx); err != nil {
+			errs = append(errs, fmt.Errorf("shutdown hook %q: %w", h.name, err))
+		}
+
+		cancel()
+	}
+
+	return errors.Join(errs...)
+}
+
+// ConfigureGracefulShutdown configures graceful shutdown on the shutdown
+// signals, defferedFunc, if not nil, runs once the shutdown has finished
+// a second signal during the drain force closes the server right away
+func (s *Server) ConfigureGracefulShutdown(defferedFunc func()) {
+	// code to support graceful shutdown
+	s.idleConnsClosed = make(chan struct{})
+
+	// register ch to receive the shutdown signals
+	// the channel is buffered so a signal sent during the drain is kept
+	ch := make(chan os.Signal, 2)
+	signal.Notify(ch, s.shutdownSignals...)
+
+	go func() {
+		defer signal.Stop(ch)
+
+		// this will block, wait for signal
+		// receive data from ch, or return if Shutdown was called directly
+		var sig os.Signal
+		select {
+		case sig = <-ch:
+		case <-s.idleConnsClosed:
+			return
+		}
+
+		log.Printf("Received signal %v, shutting down", sig)
+
+		if defferedFunc != nil {
+			defer defferedFunc()
+		}
+
+		done := make(chan struct{})
+
+		go func() {
+			select {
+			case sig := <-ch:
+				log.Printf("Received signal %v during shutdown, closing the server", sig)
+				s.closeAll()
+			case <-done:
+			}
+		}()
+
+		// Shutdown closes the idle connection close channel when done
+		s.Shutdown(context.Background())
+
+		close(done)
+	}()
+}
@@ -0,0 +1,140 @@
+//go:build unix
+
+package optionex_test
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/tanveerprottoy/advanced-go/pattern/optionex"
+)
+
+// waitReady waits for the server started in another goroutine to listen
+func waitReady(t *testing.T, srv *optionex.Server) {
+	t.Helper()
+
+	for range 100 {
+		if srv.Ready() {
+			return
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	t.Fatalf("server did not become ready")
+}
+
+func TestShutdownTimeoutAndHooks(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	defer close(release)
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(started)
+		<-release
+	})
+
+	srv := optionex.NewServer("127.0.0.1:0", handler, optionex.WithShutdownTimeout(100*time.Millisecond))
+
+	var order []string
+	srv.RegisterShutdownHook("first", 0, func(ctx context.Context) error {
+		order = append(order, "first")
+		return nil
+	})
+	srv.RegisterShutdownHook("second", 50*time.Millisecond, func(ctx context.Context) error {
+		order = append(order, "second")
+
+		// the hook has its own deadline
+		<-ctx.Done()
+
+		return ctx.Err()
+	})
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+
+	go srv.HTTPServer().Serve(ln)
+
+	go http.Get("http://" + ln.Addr().String())
+	<-started
+
+	err = srv.Shutdown(context.Background())
+
+	if !errors.Is(err, optionex.ErrShutdownTimeout) {
+		t.Errorf("Shutdown err = %v; want ErrShutdownTimeout", err)
+	}
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Shutdown err = %v; want the failed hook error", err)
+	}
+
+	if len(order) != 2 || order[0] != "first" || order[1] != "second" {
+		t.Errorf("hooks ran in order %v; want [first second]", order)
+	}
+}
+
+func TestStartWithoutGracefulShutdown(t *testing.T) {
+	srv := optionex.NewServer("127.0.0.1:0", http.NotFoundHandler())
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Start() }()
+
+	waitReady(t, srv)
+
+	if err := srv.Shutdown(context.Background()); err != nil {
+		t.Fatalf("Shutdown: %v", err)
+	}
+
+	if srv.Ready() {
+		t.Errorf("server still ready after shutdown")
+	}
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Start err = %v; want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Start did not return after Shutdown")
+	}
+}
+
+func TestGracefulShutdownOnSignal(t *testing.T) {
+	srv := optionex.NewServer(
+		"127.0.0.1:0",
+		http.NotFoundHandler(),
+		optionex.WithShutdownSignals(syscall.SIGUSR1),
+	)
+
+	deferred := make(chan struct{})
+	srv.ConfigureGracefulShutdown(func() { close(deferred) })
+
+	errc := make(chan error, 1)
+	go func() { errc <- srv.Start() }()
+
+	waitReady(t, srv)
+
+	syscall.Kill(syscall.Getpid(), syscall.SIGUSR1)
+
+	select {
+	case err := <-errc:
+		if err != nil {
+			t.Errorf("Start err = %v; want nil", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("Start did not return after the signal")
+	}
+
+	select {
+	case <-deferred:
+	case <-time.After(time.Second):
+		t.Errorf("deferred func was not called")
+	}
+}
@@ -0,0 +1,229 @@
+package optionex
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+type TimeoutOption func(*timeoutConfig)
+
+// timeoutConfig configures the Timeout middleware
+type timeoutConfig struct {
+	fallback    time.Duration
+	routes      map[string]time.Duration // path prefix to timeout, <= 0 opts out
+	status      int
+	contentType string
+	body        string
+}
+
+// WithRouteTimeout sets the timeout of requests whose path starts with
+// prefix, the longest matching prefix wins
+// a timeout <= 0 opts the route out, which streaming routes like server
+// sent events need since their responses are never buffered
+func WithRouteTimeout(prefix string, t time.Duration) TimeoutOption {
+	return func(c *timeoutConfig) {
+		c.routes[prefix] = t
+	}
+}
+
+// WithoutTimeout opts the routes under the prefixes out of the timeout
+func WithoutTimeout(prefixes ...string) TimeoutOption {
+	return func(c *timeoutConfig) {
+		for _, p := range prefixes {
+			c.routes[p] = 0
+		}
+	}
+}
+
+// WithTimeoutResponse sets the response written on timeout, by default
+// 503 Service Unavailable with a plain text body
+// 504 Gateway Timeout suits a server that proxies to upstreams
+func WithTimeoutResponse(status int, contentType, body string) TimeoutOption {
+	return func(c *timeoutConfig) {
+		c.status = status
+		c.contentType = contentType
+		c.body = body
+	}
+}
+
+// WithTimeout adds the Timeout middleware
+func WithTimeout(t time.Duration, opts ...TimeoutOption) Option {
+	return WithMiddleware(Timeout(t, opts...))
+}
+
+// Timeout runs handlers with a deadline, it is a per route version of
+// http.TimeoutHandler, and the reusable form of the pattern shown in
+// concurrency.Handler
+// the handler context is cancelled at the deadline and the timeout
+// response is written, the handler should watch r.Context().Done() and
+// return, whatever it writes after the deadline is discarded and its
+// Write calls fail with http.ErrHandlerTimeout
+// responses are buffered until the handler returns so a late write can
+// never mix with the timeout response, so the handler cannot flush or
+// hijack, routes that need to, opt out with WithoutTimeout
+func Timeout(t time.Duration, opts ...TimeoutOption) Middleware {
+	cfg := &timeoutConfig{
+		fallback:    t,
+		routes:      make(map[string]time.Duration),
+		status:      http.StatusServiceUnavailable,
+		contentType: "text/plain; charset=utf-8",
+		body:        http.StatusText(http.StatusServiceUnavailable),
+	}
+
+	for _, opt := range opts {
+		opt(cfg)
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			d := cfg.timeoutFor(r.URL.Path)
+			if d <= 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+
+			tw := &timeoutWriter{
+				ctx:    ctx,
+				header: make(http.Header),
+			}
+
+			done := make(chan struct{})
+			panicked := make(chan any, 1)
+
+			go func() {
+				defer func() {
+					if p := recover(); p != nil {
+						panicked <- p
+					}
+				}()
+
+				next.ServeHTTP(tw, r.WithContext(ctx))
+				close(done)
+			}()
+
+			finished := false
+
+			select {
+			case p := <-panicked:
+				// re-panic in the serving goroutine, so Recovery and the
+				// server see it
+				panic(p)
+			case <-done:
+				finished = true
+			case <-ctx.Done():
+			}
+
+			tw.mu.Lock()
+			defer tw.mu.Unlock()
+
+			// the handler is still running, from here on its writes fail
+			if !finished {
+				tw.timedOut = true
+			}
+
+			if tw.timedOut {
+				// the client went away, there is nobody to tell
+				if r.Context().Err() != nil {
+					return
+				}
+
+				w.Header().Set("Content-Type", cfg.contentType)
+				w.WriteHeader(cfg.status)
+				w.Write([]byte(cfg.body))
+
+				return
+			}
+
+			dst := w.Header()
+			for k, v := range tw.header {
+				dst[k] = v
+			}
+
+			if !tw.wroteHeader {
+				tw.code = http.StatusOK
+			}
+
+			w.WriteHeader(tw.code)
+			w.Write(tw.buf.Bytes())
+		})
+	}
+}
+
+// timeoutFor returns the timeout of the longest matching route prefix
+func (c *timeoutConfig) timeoutFor(path string) time.Duration {
+	d, longest := c.fallback, -1
+
+	for prefix, t := range c.routes {
+		if strings.HasPrefix(path, prefix) && len(prefix) > longest {
+			d, longest = t, len(prefix)
+		}
+	}
+
+	return d
+}
+
+// timeoutWriter buffers the response of a handler running under a
+// deadline, the mutex orders the handler's writes against the timeout
+type timeoutWriter struct {
+	ctx context.Context
+
+	mu          sync.Mutex
+	header      http.Header
+	buf         bytes.Buffer
+	code        int
+	wroteHeader bool
+	timedOut    bool
+}
+
+func (tw *timeoutWriter) Header() http.Header {
+	return tw.header
+}
+
+// expiredLocked reports whether the deadline has passed, checking the
+// context makes a write right after the deadline fail even before the
+// middleware has noticed it
+func (tw *timeoutWriter) expiredLocked() bool {
+	if !tw.timedOut && tw.ctx.Err() != nil {
+		tw.timedOut = true
+	}
+
+	return tw.timedOut
+}
+
+func (tw *timeoutWriter) Write(p []byte) (int, error) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.expiredLocked() {
+		return 0, http.ErrHandlerTimeout
+	}
+
+	if !tw.wroteHeader {
+		tw.writeHeaderLocked(http.StatusOK)
+	}
+
+	return tw.buf.Write(p)
+}
+
+func (tw *timeoutWriter) WriteHeader(code int) {
+	tw.mu.Lock()
+	defer tw.mu.Unlock()
+
+	if tw.expiredLocked() || tw.wroteHeader {
+		return
+	}
+
+	tw.writeHeaderLocked(code)
+}
+
+func (tw *timeoutWriter) writeHeaderLocked(code int) {
+	tw.wroteHeader = true
+	tw.code = code
+}
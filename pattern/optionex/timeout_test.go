@@ -0,0 +1,75 @@
+package optionex_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/tanveerprottoy/advanced-go/pattern/optionex"
+)
+
+func TestTimeout(t *testing.T) {
+	lateWrite := make(chan error, 1)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Handler", "fast")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	})
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+
+		// the timeout response is already out, this must not reach it
+		_, err := w.Write([]byte("too late"))
+		lateWrite <- err
+	})
+	mux.HandleFunc("/reports/", func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("report"))
+	})
+	mux.HandleFunc("/events", func(w http.ResponseWriter, r *http.Request) {
+		// streaming routes opt out, the writer stays flushable
+		if _, ok := w.(http.Flusher); !ok {
+			t.Errorf("ResponseWriter does not implement http.Flusher")
+		}
+
+		time.Sleep(100 * time.Millisecond)
+		w.Write([]byte("event"))
+	})
+
+	h := optionex.Timeout(
+		20*time.Millisecond,
+		optionex.WithRouteTimeout("/reports/", time.Second),
+		optionex.WithoutTimeout("/events"),
+		optionex.WithTimeoutResponse(http.StatusGatewayTimeout, "application/json", `{"error":"timeout"}`),
+	)(mux)
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/fast", http.StatusCreated, "created"},
+		{"/slow", http.StatusGatewayTimeout, `{"error":"timeout"}`},
+		{"/reports/daily", http.StatusOK, "report"},
+		{"/events", http.StatusOK, "event"},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.path, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tc.path, nil))
+
+			if rec.Code != tc.code || rec.Body.String() != tc.body {
+				t.Errorf("got %d %q; want %d %q", rec.Code, rec.Body.String(), tc.code, tc.body)
+			}
+		})
+	}
+
+	if err := <-lateWrite; !errors.Is(err, http.ErrHandlerTimeout) {
+		t.Errorf("late write err = %v; want http.ErrHandlerTimeout", err)
+	}
+}
@@ -0,0 +1,321 @@
+package optionex
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"os"
+	"sync"
+	"time"
+)
+
+// DefaultCertReloadInterval is how often the certificate files are checked
+// for changes, the check happens during a handshake, not in the background
+const DefaultCertReloadInterval = 30 * time.Second
+
+type TLSOption func(*tlsOptions)
+
+// tlsOptions collects the TLS settings before the tls.Config is built
+type tlsOptions struct {
+	minVersion       uint16
+	cipherSuites     []uint16
+	clientCAFile     string
+	clientAuth       tls.ClientAuthType
+	verifyPeer       func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
+	verifyConnection func(tls.ConnectionState) error
+	reloadInterval   time.Duration
+}
+
+// WithMinTLSVersion sets the minimum TLS version, tls.VersionTLS12 by default
+func WithMinTLSVersion(v uint16) TLSOption {
+	return func(o *tlsOptions) {
+		o.minVersion = v
+	}
+}
+
+// WithCipherSuites restricts the TLS 1.2 cipher suites, TLS 1.3 suites
+// are not configurable in crypto/tls
+func WithCipherSuites(ids ...uint16) TLSOption {
+	return func(o *tlsOptions) {
+		o.cipherSuites = ids
+	}
+}
+
+// WithClientCA enables mutual TLS, client certificates are verified
+// against the CA certificates in the PEM file caFile
+// when required is false a client without a certificate is accepted,
+// but a certificate it does present must be valid
+func WithClientCA(caFile string, required bool) TLSOption {
+	return func(o *tlsOptions) {
+		o.clientCAFile = caFile
+		o.clientAuth = tls.VerifyClientCertIfGiven
+		if required {
+			o.clientAuth = tls.RequireAndVerifyClientCert
+		}
+	}
+}
+
+// WithVerifyPeerCertificate adds a callback run after the normal
+// certificate verification, see tls.Config.VerifyPeerCertificate
+func WithVerifyPeerCertificate(f func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error) TLSOption {
+	return func(o *tlsOptions) {
+		o.verifyPeer = f
+	}
+}
+
+// WithVerifyConnection adds a callback run for every handshake, also for
+// resumed sessions, see tls.Config.VerifyConnection
+func WithVerifyConnection(f func(tls.ConnectionState) error) TLSOption {
+	return func(o *tlsOptions) {
+		o.verifyConnection = f
+	}
+}
+
+// WithCertReloadInterval sets how often the certificate files are checked
+// for changes, 0 keeps DefaultCertReloadInterval
+func WithCertReloadInterval(t time.Duration) TLSOption {
+	return func(o *tlsOptions) {
+		o.reloadInterval = t
+	}
+}
+
+// WithTLS serves HTTPS with the certificate and key in certFile and keyFile
+// the files are reloaded when they change, so a renewed certificate is
+// picked up without a restart
+func WithTLS(certFile, keyFile string, opts ...TLSOption) Option {
+	return func(srv *Server) {
+		o := newTLSOptions(opts)
+
+		r, err := newCertReloader(certFile, keyFile, o.reloadInterval)
+		if err != nil {
+			srv.initErr = errors.Join(srv.initErr, err)
+			return
+		}
+
+		cfg, err := o.config()
+		if err != nil {
+			srv.initErr = errors.Join(srv.initErr, err)
+			return
+		}
+
+		cfg.GetCertificate = r.GetCertificate
+		srv.httpServer.TLSConfig = cfg
+	}
+}
+
+// WithSelfSignedTLS serves HTTPS with a self-signed certificate generated
+// in memory for hosts, names or IP addresses, for development only
+func WithSelfSignedTLS(hosts []string, opts ...TLSOption) Option {
+	return func(srv *Server) {
+		certPEM, keyPEM, err := selfSignedCertificate(hosts, 365*24*time.Hour)
+		if err != nil {
+			srv.initErr = errors.Join(srv.initErr, err)
+			return
+		}
+
+		cert, err := tls.X509KeyPair(certPEM, keyPEM)
+		if err != nil {
+			srv.initErr = errors.Join(srv.initErr, err)
+			return
+		}
+
+		cfg, err := newTLSOptions(opts).config()
+		if err != nil {
+			srv.initErr = errors.Join(srv.initErr, err)
+			return
+		}
+
+		cfg.Certificates = []tls.Certificate{cert}
+		srv.httpServer.TLSConfig = cfg
+	}
+}
+
+func newTLSOptions(opts []TLSOption) *tlsOptions {
+	o := &tlsOptions{
+		minVersion:     tls.VersionTLS12,
+		reloadInterval: DefaultCertReloadInterval,
+	}
+
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	if o.reloadInterval <= 0 {
+		o.reloadInterval = DefaultCertReloadInterval
+	}
+
+	return o
+}
+
+// config builds the tls.Config, without the server certificate
+func (o *tlsOptions) config() (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion:            o.minVersion,
+		CipherSuites:          o.cipherSuites,
+		VerifyPeerCertificate: o.verifyPeer,
+		VerifyConnection:      o.verifyConnection,
+	}
+
+	if o.clientCAFile != "" {
+		b, err := os.ReadFile(o.clientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("optionex: read client CA: %w", err)
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(b) {
+			return nil, fmt.Errorf("optionex: no certificates found in client CA %s", o.clientCAFile)
+		}
+
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = o.clientAuth
+	}
+
+	return cfg, nil
+}
+
+// certReloader serves a certificate from disk and reloads it once the
+// files change, for tls.Config.GetCertificate
+type certReloader struct {
+	certFile string
+	keyFile  string
+	interval time.Duration
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	certMod   time.Time
+	keyMod    time.Time
+	lastCheck time.Time
+}
+
+func newCertReloader(certFile, keyFile string, interval time.Duration) (*certReloader, error) {
+	r := &certReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+		interval: interval,
+	}
+
+	// fail early, a server without a certificate cannot serve anything
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+
+	return r, nil
+}
+
+// load reads the key pair, r.mu must be held or r not shared yet
+func (r *certReloader) load() error {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return err
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return err
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("optionex: load certificate: %w", err)
+	}
+
+	r.cert = &cert
+	r.certMod = certInfo.ModTime()
+	r.keyMod = keyInfo.ModTime()
+
+	return nil
+}
+
+// changed reports whether either file has a new modification time
+func (r *certReloader) changed() bool {
+	certInfo, err := os.Stat(r.certFile)
+	if err != nil {
+		return false
+	}
+
+	keyInfo, err := os.Stat(r.keyFile)
+	if err != nil {
+		return false
+	}
+
+	return !certInfo.ModTime().Equal(r.certMod) || !keyInfo.ModTime().Equal(r.keyMod)
+}
+
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) >= r.interval {
+		r.lastCheck = time.Now()
+
+		if r.changed() {
+			// the cert and key are usually not replaced at the same
+			// instant, keep serving the old pair until the new one loads
+			if err := r.load(); err != nil {
+				log.Printf("optionex: certificate reload failed, keeping the old one: %v", err)
+			} else {
+				log.Printf("optionex: certificate reloaded from %s", r.certFile)
+			}
+		}
+	}
+
+	return r.cert, nil
+}
+
+// selfSignedCertificate generates a PEM encoded ECDSA certificate and key
+// valid for hosts, usable by servers and by clients for mutual TLS
+func selfSignedCertificate(hosts []string, validFor time.Duration) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	tmpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"optionex development"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tmpl.IPAddresses = append(tmpl.IPAddresses, ip)
+		} else {
+			tmpl.DNSNames = append(tmpl.DNSNames, h)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	return certPEM, keyPEM, nil
+}
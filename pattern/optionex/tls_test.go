@@ -0,0 +1,140 @@
+package optionex
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeKeyPair(t *testing.T, dir string, mod time.Time) (certFile, keyFile string, leaf *x509.Certificate) {
+	t.Helper()
+
+	certPEM, keyPEM, err := selfSignedCertificate([]string{"127.0.0.1"}, time.Hour)
+	if err != nil {
+		t.Fatalf("selfSignedCertificate: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "cert.pem")
+	keyFile = filepath.Join(dir, "key.pem")
+
+	for name, b := range map[string][]byte{certFile: certPEM, keyFile: keyPEM} {
+		if err := os.WriteFile(name, b, 0o600); err != nil {
+			t.Fatalf("write %s: %v", name, err)
+		}
+
+		os.Chtimes(name, mod, mod)
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		t.Fatalf("X509KeyPair: %v", err)
+	}
+
+	return certFile, keyFile, cert.Leaf
+}
+
+func TestCertReload(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+
+	certFile, keyFile, first := writeKeyPair(t, dir, now.Add(-time.Minute))
+
+	r, err := newCertReloader(certFile, keyFile, time.Nanosecond)
+	if err != nil {
+		t.Fatalf("newCertReloader: %v", err)
+	}
+
+	cert, _ := r.GetCertificate(nil)
+	if !cert.Leaf.Equal(first) {
+		t.Fatalf("GetCertificate returned an unexpected certificate")
+	}
+
+	_, _, second := writeKeyPair(t, dir, now)
+
+	cert, _ = r.GetCertificate(nil)
+	if !cert.Leaf.Equal(second) {
+		t.Errorf("GetCertificate did not pick up the new certificate")
+	}
+
+	// a broken file keeps the last good certificate in use
+	os.WriteFile(keyFile, []byte("garbage"), 0o600)
+	os.Chtimes(keyFile, now.Add(time.Minute), now.Add(time.Minute))
+
+	cert, _ = r.GetCertificate(nil)
+	if !cert.Leaf.Equal(second) {
+		t.Errorf("GetCertificate dropped the certificate after a failed reload")
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+
+	certFile, keyFile, leaf := writeKeyPair(t, dir, time.Now())
+
+	// the self-signed certificate is its own CA, it is used for the server,
+	// as the client CA and as the client certificate
+	srv := NewServer(
+		"127.0.0.1:0",
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}),
+		WithTLS(certFile, keyFile, WithClientCA(certFile, true), WithMinTLSVersion(tls.VersionTLS13)),
+	)
+
+	if srv.initErr != nil {
+		t.Fatalf("NewServer: %v", srv.initErr)
+	}
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("net.Listen: %v", err)
+	}
+
+	go srv.HTTPServer().Serve(tls.NewListener(ln, srv.HTTPServer().TLSConfig))
+	defer srv.HTTPServer().Close()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(leaf)
+
+	clientCert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("LoadX509KeyPair: %v", err)
+	}
+
+	tests := []struct {
+		name    string
+		certs   []tls.Certificate
+		wantErr bool
+	}{
+		{"rejects a client without certificate", nil, true},
+		{"accepts a client certificate", []tls.Certificate{clientCert}, false},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &http.Client{Transport: &http.Transport{
+				TLSClientConfig: &tls.Config{RootCAs: roots, Certificates: tc.certs},
+			}}
+
+			resp, err := client.Get("https://" + ln.Addr().String())
+			if err == nil {
+				resp.Body.Close()
+			}
+
+			if (err != nil) != tc.wantErr {
+				t.Errorf("Get err = %v; wantErr %v", err, tc.wantErr)
+			}
+		})
+	}
+}
+
+func TestWithTLSMissingFiles(t *testing.T) {
+	srv := NewServer("127.0.0.1:0", nil, WithTLS("missing.pem", "missing.key"))
+
+	if err := srv.Start(); err == nil {
+		t.Errorf("Start err = nil; want the certificate load error")
+	}
+}
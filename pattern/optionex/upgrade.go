@@ -0,0 +1,320 @@
+package optionex
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultUpgradeTimeout bounds how long Upgrade waits for the new process
+// to become ready
+const DefaultUpgradeTimeout = 30 * time.Second
+
+// environment of a process started by Upgrade, the listeners are passed
+// from descriptor 3 on and the readiness pipe right after them
+const (
+	upgradeListenersEnv = "OPTIONEX_UPGRADE_LISTENERS"
+	upgradeReadyMsg     = "ready"
+)
+
+var (
+	// ErrUpgradeNotServing is returned by Upgrade when the server has not
+	// started or is shutting down
+	ErrUpgradeNotServing = errors.New("optionex: upgrade needs a serving server")
+	// ErrUpgradeFailed is returned by Upgrade when the new process exited
+	// or timed out before it became ready, the old process keeps serving
+	ErrUpgradeFailed = errors.New("optionex: new process did not become ready")
+)
+
+// WithUpgrade enables zero downtime binary upgrades on the signals, SIGHUP
+// and SIGUSR2 by default, see Upgrade
+func WithUpgrade(sigs ...os.Signal) Option {
+	return func(srv *Server) {
+		if len(sigs) == 0 {
+			sigs = defaultUpgradeSignals
+		}
+
+		srv.upgradeSignals = sigs
+	}
+}
+
+// WithUpgradeTimeout sets how long Upgrade waits for the new process to
+// become ready, DefaultUpgradeTimeout by default
+func WithUpgradeTimeout(t time.Duration) Option {
+	return func(srv *Server) {
+		srv.upgradeTimeout = t
+	}
+}
+
+// Upgrade starts the current executable again, with the same arguments,
+// and hands it the listening sockets
+// the new process serves on the sockets as soon as Start runs, so both
+// processes accept connections for a moment and none is refused, once it
+// is ready this one shuts down gracefully and Start returns
+// if the new process fails to start, exits or is not ready within the
+// upgrade timeout, it is killed and this process keeps serving
+func (s *Server) Upgrade() error {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+
+	if !s.Ready() {
+		return ErrUpgradeNotServing
+	}
+
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+
+	s.listenersMu.Lock()
+	active := s.active
+	s.listenersMu.Unlock()
+
+	files := make([]*os.File, 0, len(active)+1)
+	roles := make([]string, 0, len(active))
+
+	defer func() {
+		for _, f := range files {
+			f.Close()
+		}
+	}()
+
+	for _, l := range active {
+		fl, ok := l.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			return fmt.Errorf("optionex: listener %s cannot be passed on", l.ln.Addr())
+		}
+
+		f, err := fl.File()
+		if err != nil {
+			return err
+		}
+
+		files = append(files, f)
+		roles = append(roles, strconv.Itoa(s.serverIndex(l.server)))
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	// the write end is closed by the deferred loop once the child has it
+	files = append(files, w)
+
+	cmd := exec.Command(exe, os.Args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	cmd.Env = append(os.Environ(), upgradeListenersEnv+"="+strings.Join(roles, ","))
+	cmd.ExtraFiles = files
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	// only the child holds the write end now, so a child that exits before
+	// it is ready shows up as end of file
+	w.Close()
+
+	timeout := s.upgradeTimeout
+	if timeout <= 0 {
+		timeout = DefaultUpgradeTimeout
+	}
+
+	r.SetReadDeadline(time.Now().Add(timeout))
+
+	msg, err := io.ReadAll(io.LimitReader(r, int64(len(upgradeReadyMsg))))
+	if err == nil && string(msg) != upgradeReadyMsg {
+		err = errors.New("exited before it was ready")
+	}
+
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+
+		return fmt.Errorf("%w: %v", ErrUpgradeFailed, err)
+	}
+
+	pid := cmd.Process.Pid
+
+	// the child lives on, nobody waits for it here
+	cmd.Process.Release()
+
+	log.Printf("upgraded to process %d, shutting down", pid)
+
+	s.handOff(active)
+
+	return nil
+}
+
+// handOff stops accepting connections and drains, the listening sockets
+// now belong to the new process
+// net/http drops a connection whose request was not read yet when
+// shutdown starts, so the listeners are closed first and the drain waits
+// for the requests of the connections already accepted
+func (s *Server) handOff(active []listenerSpec) {
+	// no second upgrade from this process
+	s.ready.Store(false)
+
+	s.upgradeDrained = make(chan struct{})
+	s.handedOff.Store(true)
+
+	for _, l := range active {
+		// closing the socket here must not remove the file the new
+		// process serves on
+		if ul, ok := l.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+
+		l.ln.Close()
+	}
+
+	// Upgrade may run inside a request, like an admin endpoint, the drain
+	// would wait for it
+	go func() {
+		defer close(s.upgradeDrained)
+
+		deadline := time.Now().Add(s.shutdownTimeout)
+		for s.hasNewConns() && time.Now().Before(deadline) {
+			time.Sleep(5 * time.Millisecond)
+		}
+
+		s.Shutdown(context.Background())
+	}()
+}
+
+// trackNewConns keeps track of the connections of srv that have not
+// sent a request yet
+func (s *Server) trackNewConns(srv *http.Server) {
+	prev := srv.ConnState
+
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			s.newConns.Store(c, struct{}{})
+		} else {
+			s.newConns.Delete(c)
+		}
+
+		if prev != nil {
+			prev(c, state)
+		}
+	}
+}
+
+func (s *Server) hasNewConns() bool {
+	found := false
+
+	s.newConns.Range(func(_, _ any) bool {
+		found = true
+		return false
+	})
+
+	return found
+}
+
+// serverIndex returns 0 for the main server and i for the admin server i-1
+func (s *Server) serverIndex(srv *http.Server) int {
+	for i, admin := range s.adminServers {
+		if srv == admin {
+			return i + 1
+		}
+	}
+
+	return 0
+}
+
+// upgradeListeners returns the listeners passed by the parent process,
+// ok is false if the process was not started by Upgrade
+func (s *Server) upgradeListeners() (specs []listenerSpec, ok bool, err error) {
+	v, ok := os.LookupEnv(upgradeListenersEnv)
+	if !ok {
+		return nil, false, nil
+	}
+
+	os.Unsetenv(upgradeListenersEnv)
+
+	var roles []string
+	if v != "" {
+		roles = strings.Split(v, ",")
+	}
+
+	lns, err := fileListeners(listenFDsStart, len(roles), nil, "inherited listener")
+	if err != nil {
+		return nil, true, err
+	}
+
+	// the readiness pipe follows the listeners
+	s.readyPipe = os.NewFile(uintptr(listenFDsStart+len(roles)), "upgrade ready")
+
+	specs = make([]listenerSpec, len(lns))
+
+	for i, ln := range lns {
+		idx, err := strconv.Atoi(roles[i])
+		if err != nil || idx < 0 || idx > len(s.adminServers) {
+			for _, l := range lns {
+				l.Close()
+			}
+
+			return nil, true, fmt.Errorf("optionex: inherited listener %d has unknown server %q", i, roles[i])
+		}
+
+		specs[i] = listenerSpec{ln: ln}
+		if idx > 0 {
+			specs[i].server = s.adminServers[idx-1]
+		}
+	}
+
+	return specs, true, nil
+}
+
+// notifyUpgraded tells the parent process this one is serving
+func (s *Server) notifyUpgraded() {
+	if s.readyPipe == nil {
+		return
+	}
+
+	if _, err := io.WriteString(s.readyPipe, upgradeReadyMsg); err != nil {
+		log.Printf("upgrade: notifying the parent process: %v", err)
+	}
+
+	s.readyPipe.Close()
+	s.readyPipe = nil
+}
+
+// handleUpgrades upgrades on the upgrade signals until stop is called
+func (s *Server) handleUpgrades() (stop func()) {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, s.upgradeSignals...)
+
+	done := make(chan struct{})
+
+	go func() {
+		for {
+			select {
+			case sig := <-ch:
+				log.Printf("Received signal %v, upgrading", sig)
+
+				if err := s.Upgrade(); err != nil {
+					log.Printf("upgrade failed: %v", err)
+				}
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(ch)
+		close(done)
+	}
+}
@@ -0,0 +1,11 @@
+//go:build !unix
+
+package optionex
+
+import (
+	"os"
+	"syscall"
+)
+
+// there is no SIGUSR2 outside unix
+var defaultUpgradeSignals = []os.Signal{syscall.SIGHUP}
@@ -0,0 +1,143 @@
+//go:build unix
+
+package optionex
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// upgradeChildEnv makes the test binary act as the new process of
+// TestUpgrade, Upgrade runs it with the arguments of the test run
+const upgradeChildEnv = "OPTIONEX_TEST_UPGRADE_CHILD"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(upgradeChildEnv) != "" {
+		os.Exit(runUpgradeChild())
+	}
+
+	os.Exit(m.Run())
+}
+
+// runUpgradeChild serves "child" on the inherited listeners until /quit
+func runUpgradeChild() int {
+	var srv *Server
+
+	mux := http.NewServeMux()
+	mux.Handle("/", text("child"))
+	mux.HandleFunc("/quit", func(w http.ResponseWriter, r *http.Request) {
+		go srv.Shutdown(context.Background())
+	})
+
+	srv = NewServer("127.0.0.1:0", mux)
+
+	if err := srv.Start(); err != nil {
+		return 1
+	}
+
+	return 0
+}
+
+func TestUpgrade(t *testing.T) {
+	t.Setenv(upgradeChildEnv, "1")
+
+	srv := NewServer("127.0.0.1:0", text("parent"),
+		WithUpgrade(syscall.SIGUSR2),
+		WithUpgradeTimeout(10*time.Second),
+	)
+
+	if err := srv.Upgrade(); !errors.Is(err, ErrUpgradeNotServing) {
+		t.Fatalf("Upgrade before Start = %v; want ErrUpgradeNotServing", err)
+	}
+
+	started := make(chan error, 1)
+	go func() { started <- srv.Start() }()
+
+	for deadline := time.Now().Add(time.Second); !srv.Ready(); {
+		if time.Now().After(deadline) {
+			t.Fatalf("server not ready")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	url := "http://" + srv.Addrs()[0].String()
+
+	// every request opens a new connection, a refused one fails the test
+	client := &http.Client{Transport: &http.Transport{DisableKeepAlives: true}}
+
+	var (
+		mu   sync.Mutex
+		seen = map[string]int{}
+		errs []error
+	)
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				resp, err := client.Get(url)
+				if err == nil {
+					var b []byte
+					b, err = io.ReadAll(resp.Body)
+					resp.Body.Close()
+
+					mu.Lock()
+					seen[string(b)]++
+					mu.Unlock()
+				}
+
+				if err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+
+	syscall.Kill(os.Getpid(), syscall.SIGUSR2)
+
+	select {
+	case err := <-started:
+		if err != nil {
+			t.Errorf("Start: %v", err)
+		}
+	case <-time.After(15 * time.Second):
+		t.Fatalf("the old server did not shut down")
+	}
+
+	// keep the load on the new process for a moment
+	time.Sleep(100 * time.Millisecond)
+	close(stop)
+	wg.Wait()
+
+	http.Get(url + "/quit")
+
+	if len(errs) > 0 {
+		t.Errorf("%d requests failed during the upgrade, first: %v", len(errs), errs[0])
+	}
+
+	if seen["parent"] == 0 || seen["child"] == 0 {
+		t.Errorf("responses = %v; want some from both processes", seen)
+	}
+}
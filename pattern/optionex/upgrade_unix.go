@@ -0,0 +1,10 @@
+//go:build unix
+
+package optionex
+
+import (
+	"os"
+	"syscall"
+)
+
+var defaultUpgradeSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR2}
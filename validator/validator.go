@@ -7,7 +7,7 @@ type Validator interface {
 
 // example struct
 type User struct {
-	Name  string `validate:"required",min=1,max=50`
-	Email string `validate:"required",email`
-	Age   int    `validate:"required",min=18,max=100`
+	Name  string `validate:"required,min=1,max=50"`
+	Email string `validate:"required,email"`
+	Age   int    `validate:"required,min=18,max=100"`
 }